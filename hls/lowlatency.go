@@ -0,0 +1,423 @@
+package hls
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// duration of a single partial segment produced by ffmpeg
+const lowLatencyPartDuration = 0.5
+
+// how many partial segments form one full segment
+const lowLatencyPartsPerSegment = 4
+
+// for how many last segments should partial segments be advertised
+const lowLatencyPartSegments = 3
+
+// file name of partial segment, ffmpeg must use the same naming
+const lowLatencyPartName = "part_%d.m4s"
+
+// file name of full segment, served as concatenation of its parts
+const lowLatencySegmentName = "seg_%d.m4s"
+
+var lowLatencyPartRegex = regexp.MustCompile(`^part_([0-9]+)\.m4s$`)
+var lowLatencySegmentRegex = regexp.MustCompile(`^seg_([0-9]+)\.m4s$`)
+
+type lowLatencyPart struct {
+	index    int
+	duration float64
+	uri      string
+}
+
+type lowLatencyCtx struct {
+	mu              sync.RWMutex
+	partDuration    float64
+	partsPerSegment int
+
+	initUri string
+	parts   []lowLatencyPart
+	updated chan struct{}
+}
+
+func newLowLatency(partDuration float64, partsPerSegment int) *lowLatencyCtx {
+	return &lowLatencyCtx{
+		partDuration:    partDuration,
+		partsPerSegment: partsPerSegment,
+		updated:         make(chan struct{}),
+	}
+}
+
+// environment passed to the profile, so that it produces matching parts
+func (ll *lowLatencyCtx) env() []string {
+	return []string{
+		fmt.Sprintf("HLS_PART_DURATION=%g", ll.partDuration),
+		fmt.Sprintf("HLS_SEGMENT_DURATION=%g", ll.partDuration*float64(ll.partsPerSegment)),
+	}
+}
+
+func (ll *lowLatencyCtx) reset() {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	ll.initUri = ""
+	ll.parts = nil
+}
+
+// update parts from playlist written by ffmpeg, returns number of complete segments
+func (ll *lowLatencyCtx) update(playlist string) int {
+	initUri, parts := parseLowLatencyParts(strings.NewReader(playlist))
+
+	ll.mu.Lock()
+	if initUri != "" {
+		ll.initUri = initUri
+	}
+	if len(parts) > 0 {
+		ll.parts = parts
+	}
+
+	// notify all waiting requests
+	close(ll.updated)
+	ll.updated = make(chan struct{})
+
+	segments := len(ll.completeSegments())
+	ll.mu.Unlock()
+
+	return segments
+}
+
+// parse ffmpeg media playlist, where every segment is a partial segment
+func parseLowLatencyParts(reader io.Reader) (string, []lowLatencyPart) {
+	initUri, sequence := "", 0
+	parts := []lowLatencyPart{}
+
+	var duration float64
+	var hasDuration bool
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:") {
+			sequence, _ = strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"))
+			continue
+		}
+
+		if strings.HasPrefix(line, "#EXT-X-MAP:") {
+			parts := strings.SplitN(line, "URI=\"", 2)
+			if len(parts) == 2 {
+				initUri = strings.SplitN(parts[1], "\"", 2)[0]
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, "#EXTINF:") {
+			value := strings.SplitN(strings.TrimPrefix(line, "#EXTINF:"), ",", 2)[0]
+			duration, _ = strconv.ParseFloat(value, 64)
+			hasDuration = true
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") || !hasDuration {
+			continue
+		}
+
+		parts = append(parts, lowLatencyPart{
+			index:    sequence + len(parts),
+			duration: duration,
+			uri:      line,
+		})
+
+		hasDuration = false
+	}
+
+	return initUri, parts
+}
+
+// returns indexes of segments, that have all their parts available
+func (ll *lowLatencyCtx) completeSegments() []int {
+	segments := []int{}
+	if len(ll.parts) == 0 {
+		return segments
+	}
+
+	first, last := ll.parts[0].index, ll.parts[len(ll.parts)-1].index
+
+	// first segment needs to have its first part available
+	msn := first / ll.partsPerSegment
+	if first%ll.partsPerSegment != 0 {
+		msn++
+	}
+
+	for ; (msn+1)*ll.partsPerSegment-1 <= last; msn++ {
+		segments = append(segments, msn)
+	}
+
+	return segments
+}
+
+func (ll *lowLatencyCtx) lastPart() int {
+	if len(ll.parts) == 0 {
+		return -1
+	}
+
+	return ll.parts[len(ll.parts)-1].index
+}
+
+func (ll *lowLatencyCtx) segmentParts(msn int) []lowLatencyPart {
+	parts := []lowLatencyPart{}
+	for _, part := range ll.parts {
+		if part.index/ll.partsPerSegment == msn {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// check if playlist already contains requested media sequence number and part
+func (ll *lowLatencyCtx) contains(msn, part int) bool {
+	if part < 0 {
+		segments := ll.completeSegments()
+		return len(segments) > 0 && segments[len(segments)-1] >= msn
+	}
+
+	return ll.lastPart() >= msn*ll.partsPerSegment+part
+}
+
+// block until playlist contains requested media sequence number and part
+func (ll *lowLatencyCtx) wait(msn, part int, timeout time.Duration, cancel <-chan struct{}) bool {
+	deadline := time.After(timeout)
+
+	for {
+		ll.mu.RLock()
+		ok, updated := ll.contains(msn, part), ll.updated
+		ll.mu.RUnlock()
+
+		if ok {
+			return true
+		}
+
+		select {
+		case <-updated:
+		case <-deadline:
+			return false
+		case <-cancel:
+			return false
+		}
+	}
+}
+
+func (ll *lowLatencyCtx) targetDuration() int {
+	target := ll.partDuration * float64(ll.partsPerSegment)
+	for _, msn := range ll.completeSegments() {
+		var duration float64
+		for _, part := range ll.segmentParts(msn) {
+			duration += part.duration
+		}
+		target = math.Max(target, duration)
+	}
+	return int(math.Ceil(target))
+}
+
+func (ll *lowLatencyCtx) playlist() string {
+	ll.mu.RLock()
+	defer ll.mu.RUnlock()
+
+	segments := ll.completeSegments()
+
+	mediaSequence := 0
+	if len(segments) > 0 {
+		mediaSequence = segments[0]
+	} else if len(ll.parts) > 0 {
+		mediaSequence = ll.parts[0].index / ll.partsPerSegment
+	}
+
+	playlist := []string{
+		"#EXTM3U",
+		"#EXT-X-VERSION:9",
+		fmt.Sprintf("#EXT-X-TARGETDURATION:%d", ll.targetDuration()),
+		fmt.Sprintf("#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.3f", ll.partDuration*3),
+		fmt.Sprintf("#EXT-X-PART-INF:PART-TARGET=%.3f", ll.partDuration),
+		fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d", mediaSequence),
+	}
+
+	if ll.initUri != "" {
+		playlist = append(playlist, fmt.Sprintf("#EXT-X-MAP:URI=\"%s\"", ll.initUri))
+	}
+
+	partLines := func(parts []lowLatencyPart) {
+		for _, part := range parts {
+			line := fmt.Sprintf("#EXT-X-PART:DURATION=%.3f,URI=\"%s\"", part.duration, part.uri)
+			if part.index%ll.partsPerSegment == 0 {
+				line += ",INDEPENDENT=YES"
+			}
+			playlist = append(playlist, line)
+		}
+	}
+
+	// complete segments
+	for i, msn := range segments {
+		parts := ll.segmentParts(msn)
+
+		// advertise parts only for last few segments
+		if i >= len(segments)-lowLatencyPartSegments {
+			partLines(parts)
+		}
+
+		var duration float64
+		for _, part := range parts {
+			duration += part.duration
+		}
+
+		playlist = append(playlist,
+			fmt.Sprintf("#EXTINF:%.3f,", duration),
+			fmt.Sprintf(lowLatencySegmentName, msn),
+		)
+	}
+
+	// parts of segment in progress
+	nextMsn := mediaSequence
+	if len(segments) > 0 {
+		nextMsn = segments[len(segments)-1] + 1
+	}
+	partLines(ll.segmentParts(nextMsn))
+
+	// hint for the next part
+	playlist = append(playlist,
+		fmt.Sprintf("#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\""+lowLatencyPartName+"\"", ll.lastPart()+1),
+	)
+
+	// join with newlines
+	return strings.Join(playlist, "\n") + "\n"
+}
+
+func (m *ManagerCtx) serveLowLatencyPlaylist(w http.ResponseWriter, r *http.Request) {
+	ll := m.lowLatency
+	query := r.URL.Query()
+
+	// blocking playlist reload
+	if msnStr := query.Get("_HLS_msn"); msnStr != "" {
+		msn, err := strconv.Atoi(msnStr)
+		if err != nil || msn < 0 {
+			http.Error(w, "400 invalid _HLS_msn", http.StatusBadRequest)
+			return
+		}
+
+		part := -1
+		if partStr := query.Get("_HLS_part"); partStr != "" {
+			part, err = strconv.Atoi(partStr)
+			if err != nil || part < 0 {
+				http.Error(w, "400 invalid _HLS_part", http.StatusBadRequest)
+				return
+			}
+		}
+
+		ll.mu.RLock()
+		lastMsn := ll.lastPart() / ll.partsPerSegment
+		ll.mu.RUnlock()
+
+		// requests too far in the future are rejected
+		if msn > lastMsn+2 {
+			http.Error(w, "400 _HLS_msn too far in the future", http.StatusBadRequest)
+			return
+		}
+
+		timeout := time.Duration(ll.partDuration * float64(ll.partsPerSegment) * 3 * float64(time.Second))
+		if !ll.wait(msn, part, timeout, r.Context().Done()) {
+			http.Error(w, "503 playlist not available", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write([]byte(ll.playlist()))
+}
+
+func (m *ManagerCtx) serveLowLatencyMedia(w http.ResponseWriter, r *http.Request) {
+	ll := m.lowLatency
+	fileName := path.Base(r.URL.Path)
+
+	// full segment is concatenation of its parts
+	if matches := lowLatencySegmentRegex.FindStringSubmatch(fileName); matches != nil {
+		msn, _ := strconv.Atoi(matches[1])
+
+		ll.mu.RLock()
+		ok := ll.contains(msn, -1)
+		parts := ll.segmentParts(msn)
+		ll.mu.RUnlock()
+
+		if !ok || len(parts) != ll.partsPerSegment {
+			http.Error(w, "404 media not found", http.StatusNotFound)
+			return
+		}
+
+		m.mu.Lock()
+		m.lastRequest = time.Now()
+		m.mu.Unlock()
+
+		w.Header().Set("Content-Type", "video/mp4")
+		w.Header().Set("Cache-Control", "no-cache")
+
+		for _, part := range parts {
+			file, err := os.Open(path.Join(m.tempdir, part.uri))
+			if err != nil {
+				m.logger.Err(err).Str("part", part.uri).Msg("unable to open part")
+				return
+			}
+
+			_, err = io.Copy(w, file)
+			file.Close()
+
+			if err != nil {
+				return
+			}
+		}
+
+		return
+	}
+
+	// preload hinted part needs to be held until it is available
+	if matches := lowLatencyPartRegex.FindStringSubmatch(fileName); matches != nil {
+		index, _ := strconv.Atoi(matches[1])
+
+		ll.mu.RLock()
+		lastPart := ll.lastPart()
+		ll.mu.RUnlock()
+
+		if index == lastPart+1 {
+			timeout := time.Duration(ll.partDuration * 3 * float64(time.Second))
+			if !ll.wait(index/ll.partsPerSegment, index%ll.partsPerSegment, timeout, r.Context().Done()) {
+				http.Error(w, "404 media not found", http.StatusNotFound)
+				return
+			}
+		}
+	}
+
+	filePath := path.Join(m.tempdir, fileName)
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		m.logger.Warn().Str("path", filePath).Msg("media file not found")
+		http.Error(w, "404 media not found", http.StatusNotFound)
+		return
+	}
+
+	m.mu.Lock()
+	m.lastRequest = time.Now()
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeFile(w, r, filePath)
+}
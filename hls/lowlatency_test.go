@@ -0,0 +1,90 @@
+package hls
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLowLatencyPlaylist(t *testing.T) {
+	ll := newLowLatency(0.5, 4)
+
+	segments := ll.update(`#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-TARGETDURATION:1
+#EXT-X-MEDIA-SEQUENCE:2
+#EXT-X-MAP:URI="init.mp4"
+#EXTINF:0.500000,
+part_2.m4s
+#EXTINF:0.500000,
+part_3.m4s
+#EXTINF:0.500000,
+part_4.m4s
+#EXTINF:0.500000,
+part_5.m4s
+#EXTINF:0.500000,
+part_6.m4s
+#EXTINF:0.500000,
+part_7.m4s
+#EXTINF:0.500000,
+part_8.m4s
+`)
+
+	if segments != 1 {
+		t.Fatalf("complete segments = %d, want 1", segments)
+	}
+
+	want := `#EXTM3U
+#EXT-X-VERSION:9
+#EXT-X-TARGETDURATION:2
+#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=1.500
+#EXT-X-PART-INF:PART-TARGET=0.500
+#EXT-X-MEDIA-SEQUENCE:1
+#EXT-X-MAP:URI="init.mp4"
+#EXT-X-PART:DURATION=0.500,URI="part_4.m4s",INDEPENDENT=YES
+#EXT-X-PART:DURATION=0.500,URI="part_5.m4s"
+#EXT-X-PART:DURATION=0.500,URI="part_6.m4s"
+#EXT-X-PART:DURATION=0.500,URI="part_7.m4s"
+#EXTINF:2.000,
+seg_1.m4s
+#EXT-X-PART:DURATION=0.500,URI="part_8.m4s",INDEPENDENT=YES
+#EXT-X-PRELOAD-HINT:TYPE=PART,URI="part_9.m4s"
+`
+
+	if got := ll.playlist(); got != want {
+		t.Errorf("playlist() = \n---------- have ----------\n%s\n---------- want ----------\n%s", got, want)
+	}
+}
+
+func TestLowLatencyContains(t *testing.T) {
+	ll := newLowLatency(0.5, 4)
+	_, ll.parts = parseLowLatencyParts(strings.NewReader(`#EXTM3U
+#EXT-X-MEDIA-SEQUENCE:0
+#EXTINF:0.500000,
+part_0.m4s
+#EXTINF:0.500000,
+part_1.m4s
+#EXTINF:0.500000,
+part_2.m4s
+#EXTINF:0.500000,
+part_3.m4s
+#EXTINF:0.500000,
+part_4.m4s
+`))
+
+	tests := []struct {
+		msn  int
+		part int
+		want bool
+	}{
+		{0, -1, true},
+		{1, -1, false},
+		{1, 0, true},
+		{1, 1, false},
+	}
+
+	for _, tt := range tests {
+		if got := ll.contains(tt.msn, tt.part); got != tt.want {
+			t.Errorf("contains(%d, %d) = %v, want %v", tt.msn, tt.part, got, tt.want)
+		}
+	}
+}
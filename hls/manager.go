@@ -1,12 +1,17 @@
 package hls
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -32,6 +37,25 @@ const activeIdleTimeout = 12 * time.Second
 // how long must be iactive stream idle to be considered as dead
 const inactiveIdleTimeout = 24 * time.Second
 
+// maximum size of playlist read from stdout
+const playlistBufferSize = 4096
+
+// bandwidth advertised in master playlist, when it cannot be estimated
+const defaultBandwidth = 5000000
+
+// subtitles playlist and its language, language file is written by the profile
+// before transcoding starts, when it extracts subtitles
+const subtitlesPlaylist = "subtitles.m3u8"
+const subtitlesLanguage = "subtitles.lang"
+
+var mediaContentTypes = map[string]string{
+	".ts":   "video/MP2T",
+	".m4s":  "video/mp4",
+	".mp4":  "video/mp4",
+	".vtt":  "text/vtt",
+	".m3u8": "application/vnd.apple.mpegurl",
+}
+
 type ManagerCtx struct {
 	logger     zerolog.Logger
 	mu         sync.Mutex
@@ -50,6 +74,8 @@ type ManagerCtx struct {
 	sequence int
 	playlist string
 
+	lowLatency *lowLatencyCtx
+
 	playlistLoad chan string
 	shutdown     chan interface{}
 }
@@ -64,6 +90,16 @@ func New(cmdFactory func() *exec.Cmd) *ManagerCtx {
 	}
 }
 
+// NewLowLatency creates manager serving Low-Latency HLS, profile is expected
+// to produce fMP4 partial segments as described by HLS_PART_DURATION and
+// HLS_SEGMENT_DURATION environment variables.
+func NewLowLatency(cmdFactory func() *exec.Cmd) *ManagerCtx {
+	m := New(cmdFactory)
+	m.logger = log.With().Str("module", "hls").Str("submodule", "lowlatency").Logger()
+	m.lowLatency = newLowLatency(lowLatencyPartDuration, lowLatencyPartsPerSegment)
+	return m
+}
+
 func (m *ManagerCtx) Start() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -81,8 +117,17 @@ func (m *ManagerCtx) Start() error {
 	}
 
 	m.cmd = m.cmdFactory()
+	if m.cmd == nil {
+		os.RemoveAll(m.tempdir)
+		return errors.New("command could not be created")
+	}
 	m.cmd.Dir = m.tempdir
 
+	if m.lowLatency != nil {
+		m.cmd.Env = append(os.Environ(), m.lowLatency.env()...)
+		m.lowLatency.reset()
+	}
+
 	if m.events.onCmdLog != nil {
 		m.cmd.Stderr = utils.LogEvent(m.events.onCmdLog)
 	} else {
@@ -106,7 +151,7 @@ func (m *ManagerCtx) Start() error {
 
 	// read playlist on stdout
 	go func() {
-		buf := make([]byte, 1024)
+		buf := make([]byte, playlistBufferSize)
 
 		for {
 			n, err := read.Read(buf)
@@ -119,7 +164,14 @@ func (m *ManagerCtx) Start() error {
 					Str("playlist", m.playlist).
 					Msg("received playlist")
 
-				if m.sequence == hlsMinimumSegments {
+				// low latency stream is active once it has complete segment
+				ready := m.sequence == hlsMinimumSegments
+				if m.lowLatency != nil {
+					segments := m.lowLatency.update(m.playlist)
+					ready = !m.active && segments > 0
+				}
+
+				if ready {
 					m.active = true
 					m.playlistLoad <- m.playlist
 					close(m.playlistLoad)
@@ -232,7 +284,8 @@ func (m *ManagerCtx) Cleanup() {
 	}
 }
 
-func (m *ManagerCtx) ServePlaylist(w http.ResponseWriter, r *http.Request) {
+// start transcode if not running and wait for the first playlist
+func (m *ManagerCtx) waitForPlaylist(w http.ResponseWriter) (string, bool) {
 	m.mu.Lock()
 	m.lastRequest = time.Now()
 	m.mu.Unlock()
@@ -244,31 +297,114 @@ func (m *ManagerCtx) ServePlaylist(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			m.logger.Warn().Err(err).Msg("transcode could not be started")
 			http.Error(w, "500 not available", http.StatusInternalServerError)
-			return
+			return "", false
 		}
 	}
 
 	if !m.active {
 		select {
 		case playlist = <-m.playlistLoad:
+			// channel has already been closed by another request
+			if playlist == "" {
+				playlist = m.playlist
+			}
 		// when command exits before providing any playlist
 		case <-m.shutdown:
 			m.logger.Warn().Msg("playlist load failed because of shutdown")
 			http.Error(w, "500 playlist not available", http.StatusInternalServerError)
-			return
+			return "", false
 		case <-time.After(playlistTimeout):
 			m.logger.Warn().Msg("playlist load channel timeouted")
 			http.Error(w, "504 playlist timeout", http.StatusGatewayTimeout)
-			return
+			return "", false
 		}
 	}
 
+	return playlist, true
+}
+
+func (m *ManagerCtx) ServePlaylist(w http.ResponseWriter, r *http.Request) {
+	playlist, ok := m.waitForPlaylist(w)
+	if !ok {
+		return
+	}
+
+	if m.lowLatency != nil {
+		m.serveLowLatencyPlaylist(w, r)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
 	w.Header().Set("Cache-Control", "no-cache")
 	_, _ = w.Write([]byte(playlist))
 }
 
+// ServeMaster serves master playlist for the media playlist, advertising
+// subtitles rendition if the profile extracts subtitles.
+func (m *ManagerCtx) ServeMaster(w http.ResponseWriter, r *http.Request) {
+	playlist, ok := m.waitForPlaylist(w)
+	if !ok {
+		return
+	}
+
+	master := []string{"#EXTM3U"}
+	streamInf := fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d", m.estimateBandwidth(playlist))
+
+	// subtitles playlist might not have been written yet, profile decides
+	if lang, err := os.ReadFile(path.Join(m.tempdir, subtitlesLanguage)); err == nil {
+		media := "#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"subs\",NAME=\"Subtitles\",DEFAULT=NO,AUTOSELECT=YES"
+
+		// language is empty, if not known
+		if lang = bytes.TrimSpace(lang); len(lang) > 0 {
+			media += fmt.Sprintf(",LANGUAGE=\"%s\"", lang)
+		}
+
+		master = append(master, media+",URI=\""+subtitlesPlaylist+"\"")
+		streamInf += ",SUBTITLES=\"subs\""
+	}
+
+	master = append(master, streamInf, "index.m3u8")
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write([]byte(strings.Join(master, "\n") + "\n"))
+}
+
+// estimate bandwidth from the most recent segment in playlist
+func (m *ManagerCtx) estimateBandwidth(playlist string) int {
+	var duration float64
+
+	lines := strings.Split(playlist, "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") || i == 0 {
+			continue
+		}
+
+		info := strings.TrimSpace(lines[i-1])
+		if !strings.HasPrefix(info, "#EXTINF:") {
+			continue
+		}
+
+		duration, _ = strconv.ParseFloat(strings.SplitN(strings.TrimPrefix(info, "#EXTINF:"), ",", 2)[0], 64)
+
+		stat, err := os.Stat(path.Join(m.tempdir, path.Base(line)))
+		if err != nil || duration <= 0 {
+			break
+		}
+
+		return int(float64(stat.Size()*8) / duration)
+	}
+
+	return defaultBandwidth
+}
+
 func (m *ManagerCtx) ServeMedia(w http.ResponseWriter, r *http.Request) {
+	if m.lowLatency != nil {
+		m.serveLowLatencyMedia(w, r)
+		return
+	}
+
 	fileName := path.Base(r.URL.RequestURI())
 	path := path.Join(m.tempdir, fileName)
 
@@ -282,11 +418,45 @@ func (m *ManagerCtx) ServeMedia(w http.ResponseWriter, r *http.Request) {
 	m.lastRequest = time.Now()
 	m.mu.Unlock()
 
-	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	contentType, ok := mediaContentTypes[filepath.Ext(fileName)]
+	if !ok {
+		contentType = "application/vnd.apple.mpegurl"
+	}
+
+	w.Header().Set("Content-Type", contentType)
 	w.Header().Set("Cache-Control", "no-cache")
 	http.ServeFile(w, r, path)
 }
 
+// LatestSegment returns path to the most recent segment of an active stream.
+func (m *ManagerCtx) LatestSegment() (string, bool) {
+	m.mu.Lock()
+	running := m.cmd != nil
+	m.mu.Unlock()
+
+	// low latency parts cannot be decoded without init segment
+	if !running || !m.active || m.lowLatency != nil {
+		return "", false
+	}
+
+	lines := strings.Split(strings.TrimSpace(m.playlist), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		segmentPath := path.Join(m.tempdir, path.Base(line))
+		if _, err := os.Stat(segmentPath); err != nil {
+			return "", false
+		}
+
+		return segmentPath, true
+	}
+
+	return "", false
+}
+
 func (m *ManagerCtx) OnStart(event func()) {
 	m.events.onStart = event
 }
@@ -8,8 +8,11 @@ type Manager interface {
 	Cleanup()
 
 	ServePlaylist(w http.ResponseWriter, r *http.Request)
+	ServeMaster(w http.ResponseWriter, r *http.Request)
 	ServeMedia(w http.ResponseWriter, r *http.Request)
 
+	LatestSegment() (string, bool)
+
 	OnStart(event func())
 	OnCmdLog(event func(message string))
 	OnStop(event func(err error))
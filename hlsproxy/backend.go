@@ -0,0 +1,213 @@
+package hlsproxy
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/m1k1o/go-transcode/internal/utils"
+)
+
+// suffix of files stored by disk cache
+const diskCacheSuffix = ".hlsproxy-cache"
+
+type lruItem struct {
+	key     string
+	entry   CacheEntry
+	expires time.Time
+}
+
+// LRU cache with byte budget, storage of entries is provided by backend
+type lruCache struct {
+	mu      sync.Mutex
+	maxSize int // in bytes, 0 means unlimited
+	items   map[string]*list.Element
+	order   *list.List // most recently used at front
+
+	// entries replaced or removed while still being written,
+	// storage is released by cleanup once their writer finishes
+	detached []CacheEntry
+
+	create func(key string, expires time.Time) (CacheEntry, error)
+	remove func(entry CacheEntry)
+}
+
+func newLruCache(maxSize int) *lruCache {
+	return &lruCache{
+		maxSize: maxSize,
+		items:   map[string]*list.Element{},
+		order:   list.New(),
+		remove:  func(entry CacheEntry) {},
+	}
+}
+
+// NewMemoryCache stores entries in memory, evicting least recently used
+// entries when total size exceeds maxSize bytes (0 means unlimited).
+func NewMemoryCache(maxSize int) Cache {
+	c := newLruCache(maxSize)
+	c.create = func(key string, expires time.Time) (CacheEntry, error) {
+		return utils.NewCache(expires), nil
+	}
+	return c
+}
+
+// NewDiskCache stores entries as files in dir, evicting least recently used
+// entries when total size exceeds maxSize bytes (0 means unlimited).
+func NewDiskCache(dir string, maxSize int) (Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	// remove leftovers from previous runs
+	files, err := filepath.Glob(path.Join(dir, "*"+diskCacheSuffix))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		_ = os.Remove(file)
+	}
+
+	c := newLruCache(maxSize)
+	c.create = func(key string, expires time.Time) (CacheEntry, error) {
+		fileName := fmt.Sprintf("%x-%d%s", sha1.Sum([]byte(key)), time.Now().UnixNano(), diskCacheSuffix)
+		return utils.NewFileCache(path.Join(dir, fileName), expires)
+	}
+	c.remove = func(entry CacheEntry) {
+		if file, ok := entry.(*utils.FileCache); ok {
+			_ = file.Remove()
+		}
+	}
+	return c, nil
+}
+
+func (c *lruCache) Get(key string) (CacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	// expired entry that is still being written is served anyway
+	item := element.Value.(*lruItem)
+	if time.Now().After(item.expires) && !cacheInFlight(item.entry) {
+		c.removeElement(element)
+		return nil, false
+	}
+
+	c.order.MoveToFront(element)
+	return item.entry, true
+}
+
+func (c *lruCache) Set(key string, expires time.Time) (CacheEntry, error) {
+	entry, err := c.create(key, expires)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// replace existing entry
+	if element, ok := c.items[key]; ok {
+		c.removeElement(element)
+	}
+
+	c.items[key] = c.order.PushFront(&lruItem{
+		key:     key,
+		entry:   entry,
+		expires: expires,
+	})
+
+	c.evict()
+	return entry, nil
+}
+
+func (c *lruCache) RemoveEntry(key string, entry CacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// key could have been already replaced by another entry
+	if element, ok := c.items[key]; ok && element.Value.(*lruItem).entry == entry {
+		c.removeElement(element)
+	}
+}
+
+func (c *lruCache) Cleanup() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for element := c.order.Back(); element != nil; {
+		prev := element.Prev()
+		item := element.Value.(*lruItem)
+		if now.After(item.expires) && !cacheInFlight(item.entry) {
+			c.removeElement(element)
+		}
+		element = prev
+	}
+
+	detached := c.detached[:0]
+	for _, entry := range c.detached {
+		if cacheInFlight(entry) {
+			detached = append(detached, entry)
+		} else {
+			c.remove(entry)
+		}
+	}
+	c.detached = detached
+
+	c.evict()
+	return c.order.Len() + len(c.detached)
+}
+
+// remove least recently used entries until we fit into budget
+func (c *lruCache) evict() {
+	if c.maxSize <= 0 {
+		return
+	}
+
+	size := 0
+	for element := c.order.Front(); element != nil; element = element.Next() {
+		size += element.Value.(*lruItem).entry.Len()
+	}
+
+	// most recently used entry is always kept, entries being downloaded are served to clients
+	for element := c.order.Back(); element != nil && element != c.order.Front() && size > c.maxSize; {
+		prev := element.Prev()
+		item := element.Value.(*lruItem)
+		if !cacheInFlight(item.entry) {
+			size -= item.entry.Len()
+			c.removeElement(element)
+		}
+		element = prev
+	}
+}
+
+// whether entry is still being written, removing it would break its readers
+func cacheInFlight(entry CacheEntry) bool {
+	if closer, ok := entry.(interface{ Closed() bool }); ok {
+		return !closer.Closed()
+	}
+	return false
+}
+
+func (c *lruCache) removeElement(element *list.Element) {
+	item := element.Value.(*lruItem)
+	delete(c.items, item.key)
+	c.order.Remove(element)
+
+	// closing entry would break its writer, it is released later
+	if cacheInFlight(item.entry) {
+		c.detached = append(c.detached, item.entry)
+		return
+	}
+
+	c.remove(item.entry)
+}
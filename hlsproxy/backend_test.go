@@ -0,0 +1,116 @@
+package hlsproxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCacheEviction(t *testing.T) {
+	for name, cache := range map[string]func() Cache{
+		"memory": func() Cache { return NewMemoryCache(10) },
+		"disk": func() Cache {
+			cache, err := NewDiskCache(t.TempDir(), 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return cache
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := cache()
+			expires := time.Now().Add(time.Minute)
+
+			for _, key := range []string{"a", "b", "c"} {
+				entry, err := c.Set(key, expires)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, _ = entry.Write([]byte("12345"))
+				entry.Close()
+
+				// keep "a" recently used
+				_, _ = c.Get("a")
+			}
+
+			if remaining := c.Cleanup(); remaining != 2 {
+				t.Errorf("Cleanup() = %d, want 2", remaining)
+			}
+
+			if _, ok := c.Get("b"); ok {
+				t.Errorf("least recently used entry was not evicted")
+			}
+
+			if _, ok := c.Get("a"); !ok {
+				t.Errorf("recently used entry was evicted")
+			}
+		})
+	}
+}
+
+func TestCacheExpiration(t *testing.T) {
+	c := NewMemoryCache(0)
+
+	entry, _ := c.Set("a", time.Now().Add(-time.Second))
+	entry.Close()
+
+	if _, ok := c.Get("a"); ok {
+		t.Errorf("expired entry was returned")
+	}
+
+	if remaining := c.Cleanup(); remaining != 0 {
+		t.Errorf("Cleanup() = %d, want 0", remaining)
+	}
+}
+
+func TestCacheInFlight(t *testing.T) {
+	c := NewMemoryCache(5)
+	expires := time.Now().Add(time.Minute)
+
+	// still downloading, must not be evicted
+	entry, _ := c.Set("a", expires)
+	_, _ = entry.Write([]byte("12345"))
+
+	for _, key := range []string{"b", "c"} {
+		entry, _ := c.Set(key, expires)
+		_, _ = entry.Write([]byte("12345"))
+		entry.Close()
+	}
+
+	c.Cleanup()
+
+	if _, ok := c.Get("a"); !ok {
+		t.Errorf("in-flight entry was evicted")
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("closed entry was not evicted")
+	}
+}
+
+func TestCacheReplaceInFlight(t *testing.T) {
+	c, err := NewDiskCache(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expires := time.Now().Add(time.Minute)
+
+	// two concurrent misses for the same key
+	first, _ := c.Set("a", expires)
+	second, _ := c.Set("a", expires)
+
+	// replaced entry can still be written
+	if _, err := first.Write([]byte("12345")); err != nil {
+		t.Errorf("replaced in-flight entry was closed: %v", err)
+	}
+	first.Close()
+
+	// failing first writer must not remove entry of the second one
+	c.RemoveEntry("a", first)
+	if entry, ok := c.Get("a"); !ok || entry != second {
+		t.Errorf("newer entry was removed")
+	}
+
+	second.Close()
+	if remaining := c.Cleanup(); remaining != 1 {
+		t.Errorf("Cleanup() = %d, want 1", remaining)
+	}
+}
@@ -3,44 +3,48 @@ package hlsproxy
 import (
 	"io"
 	"time"
-
-	"github.com/m1k1o/go-transcode/internal/utils"
 )
 
-func (m *ManagerCtx) getFromCache(key string) (*utils.Cache, bool) {
-	m.cacheMu.RLock()
-	entry, ok := m.cache[key]
-	m.cacheMu.RUnlock()
+// cache can be shared by multiple managers, but entries are rewritten for each of them
+func (m *ManagerCtx) cacheKey(url string) string {
+	return m.prefix + " " + url
+}
+
+func (m *ManagerCtx) getFromCache(key string) (CacheEntry, bool) {
+	entry, ok := m.cache.Get(m.cacheKey(key))
 
-	// on cache miss
+	// on cache miss or if cache has expired
 	if !ok {
 		m.logger.Debug().Str("key", key).Msg("cache miss")
 		return nil, false
 	}
 
-	// if cache has expired
-	if time.Now().After(entry.Expires) {
-		return nil, false
-	}
-
 	// cache hit
 	m.logger.Debug().Str("key", key).Msg("cache hit")
 	return entry, true
 }
 
-func (m *ManagerCtx) saveToCache(key string, reader io.Reader, expires time.Time) *utils.Cache {
-	m.cacheMu.Lock()
-	cache := utils.NewCache(expires)
-	m.cache[key] = cache
-	m.cacheMu.Unlock()
+func (m *ManagerCtx) saveToCache(key string, reader io.Reader, expires time.Time) (CacheEntry, error) {
+	cache, err := m.cache.Set(m.cacheKey(key), expires)
+	if err != nil {
+		// close reader, if it needs to be closed
+		if closer, ok := reader.(io.ReadCloser); ok {
+			closer.Close()
+		}
+
+		return nil, err
+	}
 
 	// pipe reader to writer.
 	go func() {
-		defer cache.Close()
-
 		_, err := io.Copy(cache, reader)
+		cache.Close()
+
 		if err != nil {
 			m.logger.Err(err).Msg("error while copying to cache")
+
+			// do not serve incomplete entry to next requests
+			m.cache.RemoveEntry(m.cacheKey(key), cache)
 		}
 
 		// close reader, if it needs to be closed
@@ -52,23 +56,13 @@ func (m *ManagerCtx) saveToCache(key string, reader io.Reader, expires time.Time
 	// start periodic cleanup if not running
 	m.cleanupStart()
 
-	return cache
+	return cache, nil
 }
 
 func (m *ManagerCtx) clearCache() {
-	cacheSize := 0
-
-	m.cacheMu.Lock()
-	for key, entry := range m.cache {
-		// remove expired entries
-		if time.Now().After(entry.Expires) {
-			delete(m.cache, key)
-			m.logger.Debug().Str("key", key).Msg("cache cleanup remove expired")
-		} else {
-			cacheSize++
-		}
-	}
-	m.cacheMu.Unlock()
+	// remove expired entries
+	cacheSize := m.cache.Cleanup()
+	m.mediaCleanup()
 
 	if cacheSize == 0 {
 		m.cleanupStop()
@@ -0,0 +1,119 @@
+package hlsproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// create dedicated http client for upstream requests
+func newClient(config Config) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if config.InsecureSkipVerify {
+		transport.TLSClientConfig = &tls.Config{
+			InsecureSkipVerify: true,
+		}
+	}
+
+	if config.ProxyUrl != "" {
+		proxyUrl, err := url.Parse(config.ProxyUrl)
+		if err != nil {
+			return nil, err
+		}
+
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	}
+
+	// addresses are checked after name resolution, so that it can not change after check
+	if config.DialCheck != nil {
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+
+			dialer := &net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+				Control: func(network, address string, c syscall.RawConn) error {
+					ipStr, _, err := net.SplitHostPort(address)
+					if err != nil {
+						return err
+					}
+
+					ip := net.ParseIP(ipStr)
+					if ip == nil {
+						return fmt.Errorf("invalid address %s", address)
+					}
+
+					return config.DialCheck(host, ip)
+				},
+			}
+
+			return dialer.DialContext(ctx, network, addr)
+		}
+	}
+
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   config.Timeout,
+	}
+
+	if config.RedirectCheck != nil {
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return config.RedirectCheck(req.URL)
+		}
+	}
+
+	return client, nil
+}
+
+// get upstream resource with configured headers, query parameters and auth,
+// header contains additional request headers, e.g. Range
+func (m *ManagerCtx) httpGet(ctx context.Context, rawUrl string, header http.Header) (*http.Response, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(m.config.Query) > 0 {
+		query := u.Query()
+		for key, values := range m.config.Query {
+			query[key] = values
+		}
+		u.RawQuery = query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range m.config.Headers {
+		req.Header.Set(key, value)
+	}
+
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	if m.config.Username != "" || m.config.Password != "" {
+		req.SetBasicAuth(m.config.Username, m.config.Password)
+	}
+
+	if m.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+m.config.Token)
+	}
+
+	return m.client.Do(req)
+}
@@ -0,0 +1,100 @@
+package hlsproxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// attributes of SegmentTemplate, SegmentURL, Initialization and RepresentationIndex containing urls
+var manifestAttrRegex = regexp.MustCompile(`(\s(?:media|initialization|index|sourceURL))="([^"]*)"`)
+
+// elements containing url
+var manifestElemRegex = regexp.MustCompile(`(<(BaseURL|Location|PatchLocation)(?:\s[^>]*)?>)([^<]*)(</(?:BaseURL|Location|PatchLocation)>)`)
+
+// ServeManifest serves MPEG-DASH manifest with urls pointing to this proxy.
+func (m *ManagerCtx) ServeManifest(w http.ResponseWriter, r *http.Request) {
+	url, ok := m.requestUrl(w, r)
+	if !ok {
+		return
+	}
+
+	cache, ok := m.getFromCache(url)
+	if !ok {
+		resp, upstreamUrl, err := m.upstreamGet(context.Background(), url, nil)
+		if err != nil {
+			m.upstreamError(w, err)
+			return
+		}
+
+		manifest, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			m.logger.Err(err).Msg("unable to read HTTP")
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+			return
+		}
+
+		manifestUrl, _ := neturl.Parse(upstreamUrl + strings.TrimPrefix(url, m.baseUrl))
+
+		// replace absolute urls in manifest, relative ones are resolved by client
+		text := ManifestUrlWalk(string(manifest), func(u string) string {
+			if strings.HasPrefix(u, "/") && manifestUrl != nil {
+				// root relative urls do not need to be under base url
+				if ref, err := neturl.Parse(u); err == nil {
+					u = manifestUrl.ResolveReference(ref).String()
+				}
+			}
+
+			if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+				return u
+			}
+
+			return RelativePath(upstreamUrl, m.prefix, u)
+		})
+
+		cache, err = m.saveToCache(url, strings.NewReader(text), time.Now().Add(playlistExpiration))
+		if err != nil {
+			m.logger.Err(err).Msg("unable to save to cache")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/dash+xml")
+	w.WriteHeader(200)
+
+	cache.ServeHTTP(w)
+}
+
+// Walks MPEG-DASH manifest and replaces all urls with callback.
+func ManifestUrlWalk(manifest string, replace func(string) string) string {
+	// XML escaped urls are replaced unescaped
+	replaceEscaped := func(u string) string {
+		u = strings.ReplaceAll(u, "&amp;", "&")
+		u = replace(u)
+		return strings.ReplaceAll(u, "&", "&amp;")
+	}
+
+	manifest = manifestAttrRegex.ReplaceAllStringFunc(manifest, func(match string) string {
+		parts := manifestAttrRegex.FindStringSubmatch(match)
+		return parts[1] + "=\"" + replaceEscaped(parts[2]) + "\""
+	})
+
+	manifest = manifestElemRegex.ReplaceAllStringFunc(manifest, func(match string) string {
+		parts := manifestElemRegex.FindStringSubmatch(match)
+
+		u := strings.TrimSpace(parts[3])
+		if u == "" {
+			return match
+		}
+
+		return parts[1] + replaceEscaped(u) + parts[4]
+	})
+
+	return manifest
+}
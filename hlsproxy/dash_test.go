@@ -0,0 +1,58 @@
+package hlsproxy
+
+import (
+	"testing"
+)
+
+func TestManifestUrlWalk(t *testing.T) {
+	manifest := `<?xml version="1.0" encoding="UTF-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" type="dynamic">
+  <Location>http://example.com/live/manifest.mpd?token=a&amp;b=c</Location>
+  <BaseURL serviceLocation="a">http://example.com/live/</BaseURL>
+  <Period id="1">
+    <AdaptationSet mimeType="video/mp4">
+      <SegmentTemplate timescale="90000" media="http://example.com/live/$RepresentationID$/$Number$.m4s" initialization="$RepresentationID$/init.mp4" />
+      <Representation id="720p" bandwidth="3000000">
+        <BaseURL>../video/</BaseURL>
+      </Representation>
+    </AdaptationSet>
+    <AdaptationSet mimeType="audio/mp4">
+      <Representation id="audio" bandwidth="128000">
+        <SegmentList>
+          <Initialization sourceURL="/live/audio/init.mp4" />
+          <SegmentURL media="/live/audio/1.m4s" />
+        </SegmentList>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>
+`
+
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" type="dynamic">
+  <Location>!!http://example.com/live/manifest.mpd?token=a&amp;b=c!!</Location>
+  <BaseURL serviceLocation="a">!!http://example.com/live/!!</BaseURL>
+  <Period id="1">
+    <AdaptationSet mimeType="video/mp4">
+      <SegmentTemplate timescale="90000" media="!!http://example.com/live/$RepresentationID$/$Number$.m4s!!" initialization="!!$RepresentationID$/init.mp4!!" />
+      <Representation id="720p" bandwidth="3000000">
+        <BaseURL>!!../video/!!</BaseURL>
+      </Representation>
+    </AdaptationSet>
+    <AdaptationSet mimeType="audio/mp4">
+      <Representation id="audio" bandwidth="128000">
+        <SegmentList>
+          <Initialization sourceURL="!!/live/audio/init.mp4!!" />
+          <SegmentURL media="!!/live/audio/1.m4s!!" />
+        </SegmentList>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>
+`
+
+	got := ManifestUrlWalk(manifest, func(s string) string { return "!!" + s + "!!" })
+	if got != want {
+		t.Errorf("ManifestUrlWalk() = \n%v\nwant\n%v", got, want)
+	}
+}
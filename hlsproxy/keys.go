@@ -0,0 +1,448 @@
+package hlsproxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// default time for which are upstream keys kept in memory
+const defaultKeyExpiration = 5 * time.Minute
+
+// path under proxy prefix where our own re-encryption key is served
+const ReencryptKeyPath = "_key"
+
+type segmentKey struct {
+	method      string // empty for clear segments
+	keyUrl      string
+	iv          []byte
+	sequence    int
+	passthrough bool // served as it is, e.g. byte-range segment or init section
+	expires     time.Time
+}
+
+type keyValue struct {
+	data    []byte
+	expires time.Time
+}
+
+type keysCtx struct {
+	mu       sync.Mutex
+	urls     map[string]time.Time  // known upstream key urls, with expiration
+	values   map[string]keyValue   // fetched upstream keys
+	segments map[string]segmentKey // key used by each segment
+	own      []byte                // our re-encryption key
+}
+
+func newKeys(config Config) *keysCtx {
+	own := config.ReencryptKey
+	if config.Reencrypt && len(own) != 16 {
+		own = make([]byte, 16)
+		_, _ = rand.Read(own)
+	}
+
+	return &keysCtx{
+		urls:     map[string]time.Time{},
+		values:   map[string]keyValue{},
+		segments: map[string]segmentKey{},
+		own:      own,
+	}
+}
+
+// whether segments are decrypted or re-encrypted on our side
+func (m *ManagerCtx) keysTransform() bool {
+	return m.config.Decrypt || m.config.Reencrypt
+}
+
+// remember keys referenced by playlist and keys used by its segments
+func (m *ManagerCtx) keysUpdate(upstreamUrl, playlistUrl string, playlist string) {
+	upstreamPlaylistUrl := upstreamUrl + strings.TrimPrefix(playlistUrl, m.baseUrl)
+	keyUrls, segments := playlistKeys(upstreamPlaylistUrl, strings.NewReader(playlist))
+
+	k := m.keys
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := time.Now()
+	for _, keyUrl := range keyUrls {
+		k.urls[m.primaryUrl(upstreamUrl, keyUrl)] = now.Add(segmentExpiration)
+	}
+
+	for keyUrl, expires := range k.urls {
+		if now.After(expires) {
+			delete(k.urls, keyUrl)
+		}
+	}
+
+	// segment transformation is not needed
+	if !m.keysTransform() {
+		return
+	}
+
+	for segmentUrl, key := range segments {
+		key.keyUrl = m.primaryUrl(upstreamUrl, key.keyUrl)
+		key.expires = now.Add(segmentExpiration)
+		k.segments[m.primaryUrl(upstreamUrl, segmentUrl)] = key
+	}
+
+	for segmentUrl, key := range k.segments {
+		if now.After(key.expires) {
+			delete(k.segments, segmentUrl)
+		}
+	}
+}
+
+// map url served by upstream, e.g. mirror, to url served by primary
+func (m *ManagerCtx) primaryUrl(upstreamUrl, u string) string {
+	if strings.HasPrefix(u, upstreamUrl) {
+		return m.baseUrl + strings.TrimPrefix(u, upstreamUrl)
+	}
+	return u
+}
+
+func (m *ManagerCtx) isKey(url string) bool {
+	m.keys.mu.Lock()
+	defer m.keys.mu.Unlock()
+
+	expires, ok := m.keys.urls[url]
+	return ok && time.Now().Before(expires)
+}
+
+// splits playlist url to url without our key auth query and query for our key uri
+func (m *ManagerCtx) keyAuthQuery(playlistUrl string) (string, string) {
+	if !m.config.Reencrypt || m.config.KeyAuthQuery == "" {
+		return playlistUrl, ""
+	}
+
+	u, err := url.Parse(playlistUrl)
+	if err != nil {
+		return playlistUrl, ""
+	}
+
+	query := u.Query()
+	value := query.Get(m.config.KeyAuthQuery)
+	if value == "" {
+		return playlistUrl, ""
+	}
+
+	query.Del(m.config.KeyAuthQuery)
+	u.RawQuery = query.Encode()
+
+	return u.String(), "?" + url.Values{m.config.KeyAuthQuery: {value}}.Encode()
+}
+
+// appends query starting with ? to url, that may already have its own query
+func appendQuery(u, query string) string {
+	if strings.Contains(u, "?") {
+		return u + "&" + strings.TrimPrefix(query, "?")
+	}
+	return u + query
+}
+
+// get upstream key, kept in memory according to key cache policy
+func (m *ManagerCtx) getKey(ctx context.Context, keyUrl string) ([]byte, error) {
+	k := m.keys
+
+	k.mu.Lock()
+	value, ok := k.values[keyUrl]
+	k.mu.Unlock()
+
+	if ok && time.Now().Before(value.expires) {
+		return value.data, nil
+	}
+
+	resp, _, err := m.upstreamGet(ctx, keyUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// keys are small, do not read more than needed
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return nil, err
+	}
+
+	expiration := m.config.KeyExpiration
+	if expiration <= 0 {
+		expiration = defaultKeyExpiration
+	}
+
+	k.mu.Lock()
+	now := time.Now()
+	k.values[keyUrl] = keyValue{data, now.Add(expiration)}
+	for key, value := range k.values {
+		if now.After(value.expires) {
+			delete(k.values, key)
+		}
+	}
+	k.mu.Unlock()
+
+	return data, nil
+}
+
+func (m *ManagerCtx) serveKey(w http.ResponseWriter, r *http.Request, keyUrl string) {
+	data, err := m.getKey(r.Context(), keyUrl)
+	if err != nil {
+		m.upstreamError(w, err)
+		return
+	}
+
+	writeKey(w, data)
+}
+
+func (m *ManagerCtx) serveOwnKey(w http.ResponseWriter, r *http.Request) {
+	if !m.config.Reencrypt {
+		http.Error(w, "404 key not found", http.StatusNotFound)
+		return
+	}
+
+	if m.config.KeyAuth != nil && !m.config.KeyAuth(r) {
+		http.Error(w, "403 forbidden", http.StatusForbidden)
+		return
+	}
+
+	writeKey(w, m.keys.own)
+}
+
+func writeKey(w http.ResponseWriter, data []byte) {
+	// keys must not be stored by clients or intermediate caches
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// returns segment key, if segment needs to be decrypted or re-encrypted,
+// segments not referenced by recent playlist can not be served
+func (m *ManagerCtx) segmentKey(url string) (segmentKey, bool, error) {
+	if !m.keysTransform() {
+		return segmentKey{}, false, nil
+	}
+
+	m.keys.mu.Lock()
+	defer m.keys.mu.Unlock()
+
+	key, ok := m.keys.segments[url]
+	if !ok {
+		return key, false, fmt.Errorf("segment key is unknown, segment is not in recent playlist")
+	}
+
+	// only full segment encryption can be handled
+	if key.passthrough || (key.method != "" && key.method != "AES-128") {
+		return key, false, nil
+	}
+
+	// nothing to do for clear segments, unless we re-encrypt them
+	if key.method == "" && !m.config.Reencrypt {
+		return key, false, nil
+	}
+
+	return key, true, nil
+}
+
+// decrypt and re-encrypt segment according to config
+func (m *ManagerCtx) transformSegment(ctx context.Context, key segmentKey, body io.ReadCloser) (io.ReadCloser, int64, error) {
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if key.method == "AES-128" {
+		keyData, err := m.getKey(ctx, key.keyUrl)
+		if err != nil {
+			return nil, 0, fmt.Errorf("unable to get key: %w", err)
+		}
+
+		data, err = decryptSegment(data, keyData, key.iv)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	if m.config.Reencrypt {
+		// IV is derived from media sequence number, as it is not in our key tag
+		data, err = encryptSegment(data, m.keys.own, sequenceIV(key.sequence))
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), int64(len(data)), nil
+}
+
+func sequenceIV(sequence int) []byte {
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], uint64(sequence))
+	return iv
+}
+
+func decryptSegment(data, key, iv []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("encrypted segment size is not multiple of block size")
+	}
+
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+
+	// remove PKCS7 padding
+	padding := int(out[len(out)-1])
+	if padding == 0 || padding > aes.BlockSize || padding > len(out) {
+		return nil, fmt.Errorf("invalid segment padding")
+	}
+
+	return out[:len(out)-padding], nil
+}
+
+func encryptSegment(data, key, iv []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// add PKCS7 padding
+	padding := aes.BlockSize - len(data)%aes.BlockSize
+	data = append(data, bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	out := make([]byte, len(data))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, data)
+	return out, nil
+}
+
+// returns absolute urls of keys referenced by playlist and
+// keys with IVs used by its media segments
+func playlistKeys(playlistUrl string, reader io.Reader) ([]string, map[string]segmentKey) {
+	keyUrls := []string{}
+	segments := map[string]segmentKey{}
+
+	base, err := url.Parse(playlistUrl)
+	if err != nil {
+		return keyUrls, segments
+	}
+
+	resolve := func(uri string) string {
+		u, err := url.Parse(uri)
+		if err != nil {
+			return uri
+		}
+		return base.ResolveReference(u).String()
+	}
+
+	current := segmentKey{}
+	sequence := 0
+	isSegment, isByteRange := false, false
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		name, attributes := parseTag(line)
+		switch name {
+		case "#EXT-X-MEDIA-SEQUENCE":
+			sequence, _ = strconv.Atoi(strings.TrimPrefix(line, name+":"))
+		case "#EXT-X-KEY", "#EXT-X-SESSION-KEY":
+			method := getAttribute(attributes, "METHOD")
+			uri := getAttribute(attributes, "URI")
+			if uri != "" && method != "NONE" {
+				uri = resolve(uri)
+				keyUrls = append(keyUrls, uri)
+			}
+
+			if name == "#EXT-X-KEY" {
+				current = segmentKey{}
+				if method != "NONE" {
+					current.method = method
+					current.keyUrl = uri
+					current.iv, _ = hex.DecodeString(strings.TrimPrefix(strings.ToLower(getAttribute(attributes, "IV")), "0x"))
+				}
+			}
+		case "#EXT-X-MAP":
+			// init sections are not transformed
+			if uri := getAttribute(attributes, "URI"); uri != "" {
+				segments[resolve(uri)] = segmentKey{passthrough: true}
+			}
+		case "#EXTINF":
+			isSegment = true
+		case "#EXT-X-BYTERANGE":
+			isByteRange = true
+		default:
+			if strings.HasPrefix(line, "#") || !isSegment {
+				continue
+			}
+
+			key := current
+			key.sequence = sequence
+			if key.method != "" && len(key.iv) != aes.BlockSize {
+				key.iv = sequenceIV(sequence)
+			}
+
+			// byte-range segments can not be transformed
+			if isByteRange {
+				key = segmentKey{passthrough: true}
+			}
+			segments[resolve(line)] = key
+			isSegment, isByteRange = false, false
+			sequence++
+		}
+	}
+
+	return keyUrls, segments
+}
+
+// Rewrites key tags of playlist whose AES-128 segments are served in clear,
+// or encrypted by our key, if keyUri is not empty. Playlists using other
+// encryption methods or byte-range segments are returned unchanged.
+func RewriteKeyTags(playlist string, keyUri string) string {
+	if strings.Contains(playlist, "METHOD=SAMPLE-AES") || strings.Contains(playlist, "#EXT-X-BYTERANGE:") {
+		return playlist
+	}
+
+	isMedia := strings.Contains(playlist, "#EXTINF:")
+	keyAdded := false
+
+	lines := []string{}
+	scanner := bufio.NewScanner(strings.NewReader(playlist))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		name, _ := parseTag(line)
+
+		switch name {
+		case "#EXT-X-KEY", "#EXT-X-SESSION-KEY":
+			// upstream keys are not needed anymore
+			continue
+		case "#EXTINF":
+			if isMedia && keyUri != "" && !keyAdded {
+				lines = append(lines, fmt.Sprintf("#EXT-X-KEY:METHOD=AES-128,URI=\"%s\"", keyUri))
+				keyAdded = true
+			}
+		}
+
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
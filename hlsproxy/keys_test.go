@@ -0,0 +1,140 @@
+package hlsproxy
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPlaylistKeys(t *testing.T) {
+	keyUrls, segments := playlistKeys("http://example.com/live/index.m3u8", strings.NewReader(`#EXTM3U
+#EXT-X-MEDIA-SEQUENCE:7
+#EXT-X-MAP:URI="init.mp4"
+#EXTINF:6.000,
+clear.ts
+#EXT-X-KEY:METHOD=AES-128,URI="key1.bin"
+#EXTINF:6.000,
+seg_8.ts
+#EXT-X-KEY:METHOD=AES-128,URI="/keys/key2.bin",IV=0x000102030405060708090A0B0C0D0E0F
+#EXTINF:6.000,
+seg_9.ts
+#EXT-X-KEY:METHOD=NONE
+#EXTINF:6.000,
+seg_10.ts
+#EXTINF:6.000,
+#EXT-X-BYTERANGE:1000@0
+seg_11.ts
+`))
+
+	wantKeys := []string{"http://example.com/live/key1.bin", "http://example.com/keys/key2.bin"}
+	if !reflect.DeepEqual(keyUrls, wantKeys) {
+		t.Errorf("playlistKeys() keys = %v, want %v", keyUrls, wantKeys)
+	}
+
+	wantSegments := map[string]segmentKey{
+		"http://example.com/live/init.mp4":  {passthrough: true},
+		"http://example.com/live/clear.ts":  {sequence: 7},
+		"http://example.com/live/seg_8.ts":  {method: "AES-128", keyUrl: "http://example.com/live/key1.bin", iv: sequenceIV(8), sequence: 8},
+		"http://example.com/live/seg_9.ts":  {method: "AES-128", keyUrl: "http://example.com/keys/key2.bin", iv: []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}, sequence: 9},
+		"http://example.com/live/seg_10.ts": {sequence: 10},
+		"http://example.com/live/seg_11.ts": {passthrough: true},
+	}
+	if !reflect.DeepEqual(segments, wantSegments) {
+		t.Errorf("playlistKeys() segments = %v, want %v", segments, wantSegments)
+	}
+}
+
+func TestSegmentEncryption(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	data := bytes.Repeat([]byte{0x47}, 188*3)
+
+	encrypted, err := encryptSegment(append([]byte{}, data...), key, sequenceIV(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(encrypted)%16 != 0 || bytes.Equal(encrypted[:len(data)], data) {
+		t.Fatalf("segment is not encrypted")
+	}
+
+	decrypted, err := decryptSegment(encrypted, key, sequenceIV(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decrypted, data) {
+		t.Errorf("decrypted segment does not match original")
+	}
+}
+
+func TestRewriteKeyTags(t *testing.T) {
+	playlist := `#EXTM3U
+#EXT-X-TARGETDURATION:6
+#EXT-X-KEY:METHOD=AES-128,URI="/hlsproxy/a/key.bin"
+#EXTINF:6.000,
+seg_1.ts
+#EXTINF:6.000,
+seg_2.ts
+`
+
+	tests := []struct {
+		name     string
+		playlist string
+		keyUri   string
+		want     string
+	}{
+		{
+			name:     "decrypt",
+			playlist: playlist,
+			want:     "#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXTINF:6.000,\nseg_1.ts\n#EXTINF:6.000,\nseg_2.ts\n",
+		},
+		{
+			name:     "reencrypt",
+			playlist: playlist,
+			keyUri:   "/hlsproxy/a/_key",
+			want:     "#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXT-X-KEY:METHOD=AES-128,URI=\"/hlsproxy/a/_key\"\n#EXTINF:6.000,\nseg_1.ts\n#EXTINF:6.000,\nseg_2.ts\n",
+		},
+		{
+			name:     "sample aes is kept",
+			playlist: strings.Replace(playlist, "AES-128", "SAMPLE-AES", 1),
+			keyUri:   "/hlsproxy/a/_key",
+			want:     strings.Replace(playlist, "AES-128", "SAMPLE-AES", 1),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RewriteKeyTags(tt.playlist, tt.keyUri); got != tt.want {
+				t.Errorf("RewriteKeyTags() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyAuthQuery(t *testing.T) {
+	m := New(Config{BaseUrl: "http://example.com/", Reencrypt: true, KeyAuthQuery: "token"})
+
+	playlistUrl, keyQuery := m.keyAuthQuery("http://example.com/live/index.m3u8?a=1&token=secret")
+	if playlistUrl != "http://example.com/live/index.m3u8?a=1" || keyQuery != "?token=secret" {
+		t.Errorf("keyAuthQuery() = %q, %q", playlistUrl, keyQuery)
+	}
+
+	playlistUrl, keyQuery = m.keyAuthQuery("http://example.com/live/index.m3u8")
+	if playlistUrl != "http://example.com/live/index.m3u8" || keyQuery != "" {
+		t.Errorf("keyAuthQuery() = %q, %q", playlistUrl, keyQuery)
+	}
+}
+
+func TestAppendQuery(t *testing.T) {
+	tests := map[string]string{
+		"video/index.m3u8":     "video/index.m3u8?token=secret",
+		"video/index.m3u8?a=1": "video/index.m3u8?a=1&token=secret",
+	}
+
+	for u, want := range tests {
+		if got := appendQuery(u, "?token=secret"); got != want {
+			t.Errorf("appendQuery(%q) = %q, want %q", u, got, want)
+		}
+	}
+}
@@ -2,14 +2,17 @@ package hlsproxy
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"io"
 	"net/http"
+	neturl "net/url"
+	"path"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/m1k1o/go-transcode/internal/utils"
-
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
@@ -23,64 +26,145 @@ const segmentExpiration = 60 * time.Second
 // how long should be playlist kept in memory
 const playlistExpiration = 1 * time.Second
 
+// upstream request timeout, when not configured
+const defaultTimeout = 30 * time.Second
+
 type ManagerCtx struct {
 	logger  zerolog.Logger
+	config  Config
 	baseUrl string
 	prefix  string
+	client  *http.Client
+
+	cache    Cache
+	prefetch *prefetchCtx
+	keys     *keysCtx
 
-	cache   map[string]*utils.Cache
-	cacheMu sync.RWMutex
+	media     map[string]*mediaMeta
+	mediaMu   sync.Mutex
+	upstreams *upstreamsCtx
 
 	cleanup   bool
 	cleanupMu sync.RWMutex
 	shutdown  chan struct{}
 }
 
-func New(baseUrl string, prefix string) *ManagerCtx {
+func New(config Config) *ManagerCtx {
+	logger := log.With().Str("module", "hlsproxy").Str("submodule", "manager").Logger()
+
 	// ensure it ends with slash
-	baseUrl = strings.TrimSuffix(baseUrl, "/")
+	baseUrl := strings.TrimSuffix(config.BaseUrl, "/")
 	baseUrl += "/"
 
+	baseUrls := []string{baseUrl}
+	for _, mirror := range config.Mirrors {
+		baseUrls = append(baseUrls, strings.TrimSuffix(mirror, "/")+"/")
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = defaultTimeout
+	}
+
+	// use unlimited memory cache by default
+	cache := config.Cache
+	if cache == nil {
+		cache = NewMemoryCache(0)
+	}
+
+	client, err := newClient(config)
+	if err != nil {
+		logger.Err(err).Msg("unable to create http client, using default")
+		client = http.DefaultClient
+	}
+
 	return &ManagerCtx{
-		logger:  log.With().Str("module", "hlsproxy").Str("submodule", "manager").Logger(),
+		logger:  logger,
+		config:  config,
 		baseUrl: baseUrl,
-		prefix:  prefix,
-		cache:   map[string]*utils.Cache{},
+		prefix:  config.Prefix,
+		client:  client,
+		cache:   cache,
+
+		prefetch: newPrefetch(config),
+		keys:     newKeys(config),
+
+		media:     map[string]*mediaMeta{},
+		upstreams: newUpstreams(baseUrls),
 	}
 }
 
 func (m *ManagerCtx) Shutdown() {
+	m.prefetchStop()
 	m.cleanupStop()
 }
 
 func (m *ManagerCtx) ServePlaylist(w http.ResponseWriter, r *http.Request) {
-	url := m.baseUrl + strings.TrimPrefix(r.URL.String(), m.prefix)
+	url, ok := m.requestUrl(w, r)
+	if !ok {
+		return
+	}
+
+	var prefetchCtx context.Context
+	if m.config.Prefetch > 0 {
+		prefetchCtx = m.prefetchTouch()
+	}
+
+	// playlists are cached for each key auth token, but it is not sent upstream
+	playlistUrl, keyQuery := m.keyAuthQuery(url)
 
 	cache, ok := m.getFromCache(url)
 	if !ok {
-		resp, err := http.Get(url)
+		resp, upstreamUrl, err := m.upstreamGet(context.Background(), playlistUrl, nil)
 		if err != nil {
-			m.logger.Err(err).Msg("unable to get HTTP")
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			m.upstreamError(w, err)
 			return
 		}
 
-		if resp.StatusCode < 200 && resp.StatusCode >= 300 {
-			// read all response body
-			io.Copy(io.Discard, resp.Body)
-			resp.Body.Close()
-
-			m.logger.Err(err).Int("code", resp.StatusCode).Msg("invalid HTTP response")
+		playlist, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			m.logger.Err(err).Msg("unable to read HTTP")
 			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
 			return
 		}
 
+		m.keysUpdate(upstreamUrl, playlistUrl, string(playlist))
+
+		if prefetchCtx != nil {
+			m.prefetchPlaylist(prefetchCtx, upstreamUrl, playlistUrl, string(playlist))
+		}
+
 		// replace all urls in playlist with relative ones
-		text := PlaylistUrlWalk(resp.Body, func(u string) string {
-			return RelativePath(m.baseUrl, m.prefix, u)
+		text := PlaylistUrlWalk(bytes.NewReader(playlist), func(u string) string {
+			return RelativePath(upstreamUrl, m.prefix, u)
 		})
 
-		cache = m.saveToCache(url, strings.NewReader(text), time.Now().Add(playlistExpiration))
+		// apply rewriting rules and offer additional variants
+		text = RewritePlaylist(text, m.config.Rules)
+		text = AppendVariants(text, m.config.Variants)
+
+		// segments are served in clear or encrypted by our key
+		if m.keysTransform() {
+			keyUri := ""
+			if m.config.Reencrypt {
+				keyUri = m.prefix + ReencryptKeyPath + keyQuery
+			}
+			text = RewriteKeyTags(text, keyUri)
+		}
+
+		// variant playlists of master playlist need key auth query as well
+		if keyQuery != "" && !strings.Contains(text, "#EXTINF:") {
+			text = PlaylistUrlWalk(strings.NewReader(text), func(u string) string {
+				return appendQuery(u, keyQuery)
+			})
+		}
+
+		cache, err = m.saveToCache(url, strings.NewReader(text), time.Now().Add(playlistExpiration))
+		if err != nil {
+			m.logger.Err(err).Msg("unable to save to cache")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
@@ -90,36 +174,110 @@ func (m *ManagerCtx) ServePlaylist(w http.ResponseWriter, r *http.Request) {
 }
 
 func (m *ManagerCtx) ServeMedia(w http.ResponseWriter, r *http.Request) {
-	url := m.baseUrl + strings.TrimPrefix(r.URL.String(), m.prefix)
+	url, ok := m.requestUrl(w, r)
+	if !ok {
+		return
+	}
+
+	if strings.TrimPrefix(r.URL.Path, m.prefix) == ReencryptKeyPath {
+		m.serveOwnKey(w, r)
+		return
+	}
+
+	// keys have their own cache policy
+	if m.isKey(url) {
+		m.serveKey(w, r, url)
+		return
+	}
+
+	if m.config.Prefetch > 0 {
+		m.prefetchWait(r, url)
+	}
+
+	// only single range requests are supported
+	rangeHeader := r.Header.Get("Range")
+	if strings.Contains(rangeHeader, ",") {
+		rangeHeader = ""
+	}
+
+	cache, meta, ok := m.getMedia(url)
+	if !ok && rangeHeader != "" && m.hasMedia(url+"#"+rangeHeader) {
+		// range has already been forwarded
+		m.serveRangeForward(w, r, url, rangeHeader)
+		return
+	}
 
-	cache, ok := m.getFromCache(url)
 	if !ok {
-		resp, err := http.Get(url)
+		med, err := m.fetchMedia(context.Background(), url, nil)
 		if err != nil {
-			m.logger.Err(err).Msg("unable to get HTTP")
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			m.upstreamError(w, err)
 			return
 		}
 
-		if resp.StatusCode < 200 && resp.StatusCode >= 300 {
-			// read all response body
-			io.Copy(io.Discard, resp.Body)
-			resp.Body.Close()
+		// do not cache large resources whole, only requested ranges
+		if rangeHeader != "" && med.length > m.rangeCacheLimit() && !med.transformed {
+			med.body.Close()
+			m.serveRangeForward(w, r, url, rangeHeader)
+			return
+		}
 
-			m.logger.Err(err).Int("code", resp.StatusCode).Msg("invalid HTTP response")
-			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		cache, meta, err = m.saveMedia(url, med, time.Now().Add(segmentExpiration))
+		if err != nil {
+			m.logger.Err(err).Msg("unable to save to cache")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
+	}
 
-		cache = m.saveToCache(url, resp.Body, time.Now().Add(segmentExpiration))
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	if rangeHeader != "" {
+		m.serveRange(w, r, cache, meta, rangeHeader)
+		return
 	}
 
-	w.Header().Set("Content-Type", "video/MP2T")
+	w.Header().Set("Content-Type", meta.contentType)
+	if size := meta.size(); size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
 	w.WriteHeader(200)
 
 	cache.ServeHTTP(w)
 }
 
+// upstream url of request, path must not leave upstream host nor base path
+func (m *ManagerCtx) requestUrl(w http.ResponseWriter, r *http.Request) (string, bool) {
+	url := m.baseUrl + strings.TrimPrefix(r.URL.String(), m.prefix)
+
+	// decoded path, so that encoded dots are checked as well
+	if p := path.Clean(strings.TrimPrefix(r.URL.Path, m.prefix)); p == ".." || strings.HasPrefix(p, "../") {
+		http.Error(w, "400 invalid path", http.StatusBadRequest)
+		return "", false
+	}
+
+	base, err := neturl.Parse(m.baseUrl)
+	if err != nil {
+		m.logger.Err(err).Msg("invalid base url")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return "", false
+	}
+
+	u, err := neturl.Parse(url)
+	if err != nil || u.Scheme != base.Scheme || u.Host != base.Host || u.User.String() != base.User.String() {
+		http.Error(w, "400 invalid path", http.StatusBadRequest)
+		return "", false
+	}
+
+	return url, true
+}
+
+func (m *ManagerCtx) rangeCacheLimit() int64 {
+	if m.config.RangeCacheLimit > 0 {
+		return m.config.RangeCacheLimit
+	}
+	return defaultRangeCacheLimit
+}
+
 // resolve path: remove ../ and ./ from path
 func resolvePath(path string) string {
 	parts := strings.Split(path, "/")
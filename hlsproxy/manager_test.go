@@ -3,6 +3,7 @@ package hlsproxy
 import (
 	"bytes"
 	"io"
+	"net/http/httptest"
 	"reflect"
 	"regexp"
 	"strings"
@@ -231,3 +232,57 @@ func TestRelativePath(t *testing.T) {
 		})
 	}
 }
+
+func TestAppendVariants(t *testing.T) {
+	variants := []Variant{
+		{Uri: "/hlsproxy/a/_transcode/h264_720p/index.m3u8", Bandwidth: 2928000, Resolution: "1280x720"},
+		{Uri: "/hlsproxy/a/_transcode/audio/index.m3u8", Bandwidth: 128000, Codecs: "mp4a.40.2"},
+	}
+
+	master := "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=8000000,CODECS=\"hvc1.2.4.L150\"\n1080p.m3u8"
+	want := master + `
+#EXT-X-STREAM-INF:BANDWIDTH=2928000,RESOLUTION=1280x720
+/hlsproxy/a/_transcode/h264_720p/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=128000,CODECS="mp4a.40.2"
+/hlsproxy/a/_transcode/audio/index.m3u8
+`
+	if got := AppendVariants(master, variants); got != want {
+		t.Errorf("AppendVariants() = %v, want %v", got, want)
+	}
+
+	media := "#EXTM3U\n#EXTINF:2,\nseg.ts\n"
+	if got := AppendVariants(media, variants); got != media {
+		t.Errorf("AppendVariants() = %v, want %v", got, media)
+	}
+}
+
+func TestRequestUrl(t *testing.T) {
+	tests := []struct {
+		baseUrl string
+		path    string
+		want    bool
+	}{
+		{"http://example.com/live/", "/proxy/index.m3u8", true},
+		{"http://example.com/", "/proxy/@10.0.0.1/x.m3u8", true}, // only path
+		{"http://example.com/", "/proxy//other.com/x.m3u8", true},
+		{"http://example.com/live/", "/proxy/a/../index.m3u8", true},
+		// path must not leave base path
+		{"http://example.com/live/", "/proxy/../secret/x.m3u8", false},
+		{"http://example.com/live/", "/proxy/a/../../secret/x.m3u8", false},
+		{"http://example.com/live/", "/proxy/%2e%2e/secret/x.m3u8", false},
+		// base without trailing slash must not allow changing host
+		{"http://example.com", "/proxy/@10.0.0.1/x.m3u8", false},
+		{"http://example.com", "/proxy/.evil.com/x.m3u8", false},
+		{"http://example.com", "/proxy/:8080/x.m3u8", false},
+	}
+
+	for _, tt := range tests {
+		m := &ManagerCtx{baseUrl: tt.baseUrl, prefix: "/proxy/"}
+
+		w := httptest.NewRecorder()
+		_, got := m.requestUrl(w, httptest.NewRequest("GET", tt.path, nil))
+		if got != tt.want {
+			t.Errorf("requestUrl(%q, %q) = %v, want %v", tt.baseUrl, tt.path, got, tt.want)
+		}
+	}
+}
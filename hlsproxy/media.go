@@ -0,0 +1,352 @@
+package hlsproxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// larger resources are not cached whole, range requests are forwarded instead
+const defaultRangeCacheLimit = 64 * 1024 * 1024
+
+// used when upstream does not provide meaningful content type
+var mediaContentTypes = map[string]string{
+	".ts":   "video/MP2T",
+	".m4s":  "video/iso.segment",
+	".mp4":  "video/mp4",
+	".m4a":  "audio/mp4",
+	".m4v":  "video/mp4",
+	".aac":  "audio/aac",
+	".mp3":  "audio/mpeg",
+	".vtt":  "text/vtt",
+	".webm": "video/webm",
+}
+
+// upstream media response
+type media struct {
+	body         io.ReadCloser
+	contentType  string
+	contentRange string // for partial responses
+	length       int64  // -1 if unknown
+	transformed  bool   // decrypted or re-encrypted, whole body is in memory
+}
+
+// media stored in cache
+type mediaMeta struct {
+	mu           sync.Mutex
+	contentType  string
+	contentRange string
+	length       int64
+	done         chan struct{} // closed when whole body has been cached
+	expires      time.Time
+}
+
+func (meta *mediaMeta) size() int64 {
+	meta.mu.Lock()
+	defer meta.mu.Unlock()
+	return meta.length
+}
+
+// wait until whole body is cached, if its size is unknown
+func (meta *mediaMeta) waitSize(ctx context.Context) (int64, bool) {
+	if size := meta.size(); size >= 0 {
+		return size, true
+	}
+
+	select {
+	case <-meta.done:
+		return meta.size(), true
+	case <-ctx.Done():
+		return -1, false
+	}
+}
+
+// counts cached bytes, to learn unknown size
+type mediaBody struct {
+	io.ReadCloser
+	meta *mediaMeta
+	n    int64
+	once sync.Once
+}
+
+func (b *mediaBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+func (b *mediaBody) Close() error {
+	b.once.Do(func() {
+		b.meta.mu.Lock()
+		if b.meta.length < 0 {
+			b.meta.length = b.n
+		}
+		b.meta.mu.Unlock()
+		close(b.meta.done)
+	})
+	return b.ReadCloser.Close()
+}
+
+// get media from upstream, decrypted or re-encrypted if needed
+func (m *ManagerCtx) fetchMedia(ctx context.Context, url string, header http.Header) (media, error) {
+	// ranges are forwarded only for segments that are not transformed
+	key, transform := segmentKey{}, false
+	if header.Get("Range") == "" {
+		var err error
+		key, transform, err = m.segmentKey(url)
+		if err != nil {
+			return media{}, err
+		}
+	}
+
+	resp, _, err := m.upstreamGet(ctx, url, header)
+	if err != nil {
+		return media{}, err
+	}
+
+	med := media{
+		body:         resp.Body,
+		contentType:  mediaContentType(url, resp.Header.Get("Content-Type")),
+		contentRange: resp.Header.Get("Content-Range"),
+		length:       resp.ContentLength,
+	}
+
+	// upstream ignored our range request
+	if header.Get("Range") != "" && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return media{}, fmt.Errorf("upstream does not support range requests")
+	}
+
+	if transform {
+		med.body, med.length, err = m.transformSegment(ctx, key, resp.Body)
+		if err != nil {
+			return media{}, err
+		}
+		med.transformed = true
+	}
+
+	return med, nil
+}
+
+func (m *ManagerCtx) getMedia(key string) (CacheEntry, *mediaMeta, bool) {
+	m.mediaMu.Lock()
+	meta, ok := m.media[key]
+	m.mediaMu.Unlock()
+
+	if !ok || time.Now().After(meta.expires) {
+		return nil, nil, false
+	}
+
+	entry, ok := m.getFromCache(key)
+	if !ok {
+		return nil, nil, false
+	}
+
+	return entry, meta, true
+}
+
+func (m *ManagerCtx) hasMedia(key string) bool {
+	_, _, ok := m.getMedia(key)
+	return ok
+}
+
+func (m *ManagerCtx) saveMedia(key string, med media, expires time.Time) (CacheEntry, *mediaMeta, error) {
+	meta := &mediaMeta{
+		contentType:  med.contentType,
+		contentRange: med.contentRange,
+		length:       med.length,
+		done:         make(chan struct{}),
+		expires:      expires,
+	}
+
+	entry, err := m.saveToCache(key, &mediaBody{ReadCloser: med.body, meta: meta}, expires)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m.mediaMu.Lock()
+	m.media[key] = meta
+	m.mediaMu.Unlock()
+
+	return entry, meta, nil
+}
+
+// remove metadata of expired media
+func (m *ManagerCtx) mediaCleanup() {
+	m.mediaMu.Lock()
+	defer m.mediaMu.Unlock()
+
+	now := time.Now()
+	for key, meta := range m.media {
+		if now.After(meta.expires) {
+			delete(m.media, key)
+		}
+	}
+}
+
+// serve only requested slice of cached media
+func (m *ManagerCtx) serveRange(w http.ResponseWriter, r *http.Request, entry CacheEntry, meta *mediaMeta, rangeHeader string) {
+	size, ok := meta.waitSize(r.Context())
+	if !ok {
+		return
+	}
+
+	start, end, ok := parseRange(rangeHeader, size)
+	if !ok {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		http.Error(w, http.StatusText(http.StatusRequestedRangeNotSatisfiable), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	w.Header().Set("Content-Type", meta.contentType)
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	w.WriteHeader(http.StatusPartialContent)
+
+	entry.ServeHTTP(&rangeWriter{w: w, skip: start, remaining: end - start + 1})
+}
+
+// forward range request to upstream, caching only requested slice
+func (m *ManagerCtx) serveRangeForward(w http.ResponseWriter, r *http.Request, url string, rangeHeader string) {
+	key := url + "#" + rangeHeader
+
+	entry, meta, ok := m.getMedia(key)
+	if !ok {
+		med, err := m.fetchMedia(context.Background(), url, http.Header{"Range": []string{rangeHeader}})
+		if err != nil {
+			m.upstreamError(w, err)
+			return
+		}
+
+		entry, meta, err = m.saveMedia(key, med, time.Now().Add(segmentExpiration))
+		if err != nil {
+			m.logger.Err(err).Msg("unable to save to cache")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Type", meta.contentType)
+	w.Header().Set("Content-Range", meta.contentRange)
+	if size := meta.size(); size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	w.WriteHeader(http.StatusPartialContent)
+
+	entry.ServeHTTP(w)
+}
+
+// writes only requested slice of data
+type rangeWriter struct {
+	w         http.ResponseWriter
+	skip      int64
+	remaining int64
+}
+
+func (rw *rangeWriter) Header() http.Header {
+	return rw.w.Header()
+}
+
+func (rw *rangeWriter) WriteHeader(statusCode int) {
+	rw.w.WriteHeader(statusCode)
+}
+
+func (rw *rangeWriter) Write(p []byte) (int, error) {
+	n := len(p)
+
+	if rw.skip > 0 {
+		if int64(len(p)) <= rw.skip {
+			rw.skip -= int64(len(p))
+			return n, nil
+		}
+		p = p[rw.skip:]
+		rw.skip = 0
+	}
+
+	if rw.remaining <= 0 {
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > rw.remaining {
+		p = p[:rw.remaining]
+	}
+
+	written, err := rw.w.Write(p)
+	rw.remaining -= int64(written)
+	if err != nil {
+		return written, err
+	}
+
+	return n, nil
+}
+
+// parse single byte range, end is inclusive
+func parseRange(rangeHeader string, size int64) (int64, int64, bool) {
+	spec := strings.TrimPrefix(rangeHeader, "bytes=")
+	if spec == rangeHeader || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+
+	parts := strings.SplitN(strings.TrimSpace(spec), "-", 2)
+	if len(parts) != 2 || size <= 0 {
+		return 0, 0, false
+	}
+
+	// suffix range, last n bytes
+	if parts[0] == "" {
+		n, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true
+	}
+
+	start, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+
+	end := size - 1
+	if parts[1] != "" {
+		if end, err = strconv.ParseInt(parts[1], 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+
+	return start, end, true
+}
+
+// content type from upstream, unless it is missing or generic
+func mediaContentType(url, contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "", "application/octet-stream", "binary/octet-stream", "text/plain":
+	default:
+		return contentType
+	}
+
+	// strip query parameters
+	if i := strings.IndexAny(url, "?#"); i >= 0 {
+		url = url[:i]
+	}
+
+	if contentType, ok := mediaContentTypes[strings.ToLower(path.Ext(url))]; ok {
+		return contentType
+	}
+
+	return "application/octet-stream"
+}
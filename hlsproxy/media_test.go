@@ -0,0 +1,51 @@
+package hlsproxy
+
+import (
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header string
+		size   int64
+		start  int64
+		end    int64
+		ok     bool
+	}{
+		{"bytes=0-99", 1000, 0, 99, true},
+		{"bytes=900-", 1000, 900, 999, true},
+		{"bytes=900-2000", 1000, 900, 999, true},
+		{"bytes=-100", 1000, 900, 999, true},
+		{"bytes=-2000", 1000, 0, 999, true},
+		{"bytes=1000-", 1000, 0, 0, false},
+		{"bytes=50-10", 1000, 0, 0, false},
+		{"bytes=0-1,5-6", 1000, 0, 0, false},
+		{"items=0-1", 1000, 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		start, end, ok := parseRange(tt.header, tt.size)
+		if start != tt.start || end != tt.end || ok != tt.ok {
+			t.Errorf("parseRange(%q, %d) = %d, %d, %v, want %d, %d, %v", tt.header, tt.size, start, end, ok, tt.start, tt.end, tt.ok)
+		}
+	}
+}
+
+func TestMediaContentType(t *testing.T) {
+	tests := []struct {
+		url         string
+		contentType string
+		want        string
+	}{
+		{"http://example.com/seg.m4s", "video/iso.segment", "video/iso.segment"},
+		{"http://example.com/seg.ts?token=1", "application/octet-stream", "video/MP2T"},
+		{"http://example.com/init.mp4", "", "video/mp4"},
+		{"http://example.com/seg", "binary/octet-stream", "application/octet-stream"},
+	}
+
+	for _, tt := range tests {
+		if got := mediaContentType(tt.url, tt.contentType); got != tt.want {
+			t.Errorf("mediaContentType(%q, %q) = %q, want %q", tt.url, tt.contentType, got, tt.want)
+		}
+	}
+}
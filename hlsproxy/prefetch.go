@@ -0,0 +1,363 @@
+package hlsproxy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// default number of parallel prefetch downloads
+const defaultPrefetchConcurrency = 2
+
+// default time after which prefetching stops, when playlist is not requested
+const defaultPrefetchIdle = 30 * time.Second
+
+// how long should media request wait for running prefetch
+const prefetchWaitTimeout = 10 * time.Second
+
+type prefetchCtx struct {
+	mu       sync.Mutex
+	ctx      context.Context
+	cancel   context.CancelFunc
+	lastSeen time.Time // last playlist request
+	lastUrl  string    // last requested media url
+	running  map[string]*prefetchJob
+
+	sem     chan struct{}
+	limiter *rateLimiter
+}
+
+// prefetch of a segment, running until whole segment is downloaded
+type prefetchJob struct {
+	cached chan struct{} // closed when segment is in cache or prefetch failed
+	once   sync.Once
+	wanted int32 // set atomically when player waits for segment, it is not rate limited anymore
+}
+
+func (j *prefetchJob) setCached() {
+	j.once.Do(func() { close(j.cached) })
+}
+
+func newPrefetch(config Config) *prefetchCtx {
+	concurrency := config.PrefetchConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultPrefetchConcurrency
+	}
+
+	return &prefetchCtx{
+		running: map[string]*prefetchJob{},
+		sem:     make(chan struct{}, concurrency),
+		limiter: &rateLimiter{rate: config.PrefetchBandwidth},
+	}
+}
+
+// mark playlist as requested, starts prefetching context if not running
+func (m *ManagerCtx) prefetchTouch() context.Context {
+	p := m.prefetch
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.lastSeen = time.Now()
+	if p.ctx != nil {
+		return p.ctx
+	}
+
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	go m.prefetchWatch(p.ctx)
+
+	m.logger.Debug().Msg("prefetch started")
+	return p.ctx
+}
+
+// cancel all prefetches when playlist has not been requested for a while
+func (m *ManagerCtx) prefetchWatch(ctx context.Context) {
+	idle := m.config.PrefetchIdle
+	if idle <= 0 {
+		idle = defaultPrefetchIdle
+	}
+
+	ticker := time.NewTicker(idle / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p := m.prefetch
+			p.mu.Lock()
+			if time.Since(p.lastSeen) > idle {
+				p.cancel()
+				p.ctx, p.cancel = nil, nil
+				p.mu.Unlock()
+
+				m.logger.Debug().Msg("prefetch stopped, playlist is idle")
+				return
+			}
+			p.mu.Unlock()
+		}
+	}
+}
+
+func (m *ManagerCtx) prefetchStop() {
+	p := m.prefetch
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cancel != nil {
+		p.cancel()
+		p.ctx, p.cancel = nil, nil
+	}
+}
+
+// start downloading segments following the last requested one
+func (m *ManagerCtx) prefetchPlaylist(ctx context.Context, upstreamUrl, playlistUrl string, playlist string) {
+	if m.config.Prefetch <= 0 {
+		return
+	}
+
+	// playlist might have been served by mirror
+	upstreamPlaylistUrl := upstreamUrl + strings.TrimPrefix(playlistUrl, m.baseUrl)
+	segments := MediaSegments(upstreamPlaylistUrl, strings.NewReader(playlist))
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, upstreamUrl) {
+			segments[i] = m.baseUrl + strings.TrimPrefix(segment, upstreamUrl)
+		}
+	}
+
+	p := m.prefetch
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// when player did not request any segment from this playlist yet,
+	// prefetch from the live edge where players usually start
+	start := len(segments) - m.config.Prefetch
+	for i, segment := range segments {
+		if segment == p.lastUrl {
+			start = i + 1
+			break
+		}
+	}
+	if start < 0 {
+		start = 0
+	}
+
+	for i := start; i < len(segments) && i < start+m.config.Prefetch; i++ {
+		segmentUrl := segments[i]
+
+		// only urls that are served by this proxy
+		if !strings.HasPrefix(segmentUrl, m.baseUrl) {
+			continue
+		}
+
+		if _, ok := p.running[segmentUrl]; ok {
+			continue
+		}
+
+		if _, ok := m.cache.Get(m.cacheKey(segmentUrl)); ok {
+			continue
+		}
+
+		job := &prefetchJob{cached: make(chan struct{})}
+		p.running[segmentUrl] = job
+
+		go func() {
+			if err := m.prefetchSegment(ctx, segmentUrl, job); err != nil && ctx.Err() == nil {
+				m.logger.Warn().Err(err).Str("url", segmentUrl).Msg("unable to prefetch segment")
+			}
+		}()
+	}
+}
+
+func (m *ManagerCtx) prefetchSegment(ctx context.Context, segmentUrl string, job *prefetchJob) error {
+	p := m.prefetch
+
+	select {
+	case p.sem <- struct{}{}:
+	case <-ctx.Done():
+		m.prefetchFinish(segmentUrl, job)
+		return ctx.Err()
+	}
+
+	// slot is released and job finished when the whole body has been read
+	release := func() {
+		<-p.sem
+		m.prefetchFinish(segmentUrl, job)
+	}
+
+	med, err := m.fetchMedia(ctx, segmentUrl, nil)
+	if err != nil {
+		release()
+		return err
+	}
+
+	m.logger.Debug().Str("url", segmentUrl).Msg("prefetching segment")
+
+	med.body = &prefetchBody{
+		ctx:     ctx,
+		body:    med.body,
+		limiter: p.limiter,
+		job:     job,
+		release: release,
+	}
+
+	_, _, err = m.saveMedia(segmentUrl, med, time.Now().Add(segmentExpiration))
+
+	// waiting players can stream segment from cache, while it is being downloaded
+	job.setCached()
+	return err
+}
+
+func (m *ManagerCtx) prefetchFinish(segmentUrl string, job *prefetchJob) {
+	p := m.prefetch
+
+	p.mu.Lock()
+	if p.running[segmentUrl] == job {
+		delete(p.running, segmentUrl)
+	}
+	p.mu.Unlock()
+
+	job.setCached()
+}
+
+// wait for running prefetch of requested media, remember it as last requested
+func (m *ManagerCtx) prefetchWait(r *http.Request, mediaUrl string) {
+	p := m.prefetch
+
+	p.mu.Lock()
+	p.lastUrl = mediaUrl
+	job, ok := p.running[mediaUrl]
+	p.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	// player is waiting for these bytes, do not rate limit them
+	atomic.StoreInt32(&job.wanted, 1)
+
+	select {
+	case <-job.cached:
+	case <-r.Context().Done():
+	case <-time.After(prefetchWaitTimeout):
+	}
+}
+
+// prefetched response body, limited by bandwidth
+type prefetchBody struct {
+	ctx     context.Context
+	body    io.ReadCloser
+	limiter *rateLimiter
+	job     *prefetchJob
+	release func()
+	once    sync.Once
+}
+
+func (b *prefetchBody) Read(p []byte) (int, error) {
+	// read in small chunks for smooth rate limiting
+	if len(p) > 32*1024 {
+		p = p[:32*1024]
+	}
+
+	n, err := b.body.Read(p)
+	if n > 0 && atomic.LoadInt32(&b.job.wanted) == 0 {
+		if err := b.limiter.wait(b.ctx, n); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
+
+func (b *prefetchBody) Close() error {
+	b.once.Do(b.release)
+	return b.body.Close()
+}
+
+// rateLimiter is shared by all prefetches of a manager
+type rateLimiter struct {
+	mu   sync.Mutex
+	rate int64 // bytes per second, 0 means unlimited
+	next time.Time
+}
+
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	if l.rate <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Returns absolute urls of media segments in media playlist,
+// empty if it is master playlist. Byte-range segments are skipped.
+func MediaSegments(playlistUrl string, reader io.Reader) []string {
+	base, err := url.Parse(playlistUrl)
+	if err != nil {
+		return nil
+	}
+
+	segments := []string{}
+	isSegment, isByteRange := false, false
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			if strings.HasPrefix(line, "#EXTINF:") {
+				isSegment = true
+			}
+			if strings.HasPrefix(line, "#EXT-X-BYTERANGE:") {
+				isByteRange = true
+			}
+			continue
+		}
+
+		if !isSegment || isByteRange {
+			isSegment, isByteRange = false, false
+			continue
+		}
+		isSegment = false
+
+		u, err := url.Parse(line)
+		if err != nil {
+			continue
+		}
+
+		segments = append(segments, base.ResolveReference(u).String())
+	}
+
+	return segments
+}
@@ -0,0 +1,78 @@
+package hlsproxy
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMediaSegments(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			name: "media playlist",
+			input: `#EXTM3U
+				#EXT-X-TARGETDURATION:6
+				#EXT-X-KEY:METHOD=AES-128,URI="key.bin"
+				#EXTINF:6.000,
+				seg_1.ts
+				#EXTINF:6.000,
+				/live/seg_2.ts?token=1
+				#EXTINF:6.000,
+				http://cdn.example.com/seg_3.ts
+			`,
+			want: []string{
+				"http://example.com/live/ch1/seg_1.ts",
+				"http://example.com/live/seg_2.ts?token=1",
+				"http://cdn.example.com/seg_3.ts",
+			},
+		},
+		{
+			name: "master playlist",
+			input: `#EXTM3U
+				#EXT-X-STREAM-INF:BANDWIDTH=1000000
+				720p.m3u8
+			`,
+			want: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := MediaSegments("http://example.com/live/ch1/index.m3u8", strings.NewReader(tt.input))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MediaSegments() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrefetchBodyWanted(t *testing.T) {
+	job := &prefetchJob{cached: make(chan struct{}), wanted: 1}
+	body := &prefetchBody{
+		ctx:     context.Background(),
+		body:    io.NopCloser(strings.NewReader(strings.Repeat("x", 1024))),
+		limiter: &rateLimiter{rate: 1},
+		job:     job,
+		release: func() {},
+	}
+
+	// would take minutes, if it was rate limited
+	done := make(chan struct{})
+	go func() {
+		_, _ = io.ReadAll(body)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("wanted segment is rate limited")
+	}
+}
@@ -0,0 +1,258 @@
+package hlsproxy
+
+import (
+	"bufio"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Rules for rewriting proxied playlists.
+type Rules struct {
+	MaxBandwidth int      // Drop variants with higher bandwidth, 0 means unlimited.
+	MaxHeight    int      // Drop variants with higher resolution height, 0 means unlimited.
+	Order        string   // Reorder variants by bandwidth: asc or desc, empty keeps upstream order.
+	StripTags    []string // Remove tags, e.g. #EXT-X-DATERANGE or #EXT-X-CUE-OUT.
+	DefaultAudio string   // Force default audio rendition by language, e.g. en.
+	Inject       []string // Add tags after #EXTM3U.
+}
+
+func (r Rules) empty() bool {
+	return r.MaxBandwidth == 0 && r.MaxHeight == 0 && r.Order == "" &&
+		len(r.StripTags) == 0 && r.DefaultAudio == "" && len(r.Inject) == 0
+}
+
+type attribute struct {
+	key   string
+	value string // raw value, including quotes
+}
+
+// parse tag line into name and attribute list, e.g.
+// #EXT-X-STREAM-INF:BANDWIDTH=1000,CODECS="avc1,mp4a"
+func parseTag(line string) (string, []attribute) {
+	parts := strings.SplitN(line, ":", 2)
+	if len(parts) != 2 {
+		return line, nil
+	}
+
+	attributes := []attribute{}
+	rest := parts[1]
+	for rest != "" {
+		kv := strings.SplitN(rest, "=", 2)
+		if len(kv) != 2 {
+			break
+		}
+
+		key, value := strings.TrimSpace(kv[0]), kv[1]
+		end := 0
+		if strings.HasPrefix(value, "\"") {
+			// quoted string can contain commas
+			end = strings.Index(value[1:], "\"") + 2
+			if end == 1 {
+				end = len(value)
+			}
+		} else {
+			end = strings.Index(value, ",")
+			if end == -1 {
+				end = len(value)
+			}
+		}
+
+		attributes = append(attributes, attribute{key, value[:end]})
+		rest = strings.TrimPrefix(value[end:], ",")
+	}
+
+	return parts[0], attributes
+}
+
+func formatTag(name string, attributes []attribute) string {
+	list := make([]string, len(attributes))
+	for i, attr := range attributes {
+		list[i] = attr.key + "=" + attr.value
+	}
+	return name + ":" + strings.Join(list, ",")
+}
+
+func getAttribute(attributes []attribute, key string) string {
+	for _, attr := range attributes {
+		if attr.key == key {
+			return strings.Trim(attr.value, "\"")
+		}
+	}
+	return ""
+}
+
+func setAttribute(attributes []attribute, key, value string) []attribute {
+	for i, attr := range attributes {
+		if attr.key == key {
+			attributes[i].value = value
+			return attributes
+		}
+	}
+	return append(attributes, attribute{key, value})
+}
+
+type variant struct {
+	lines     []string // tags and uri
+	bandwidth int
+	height    int
+}
+
+func (r Rules) allowed(v variant) bool {
+	return (r.MaxBandwidth == 0 || v.bandwidth <= r.MaxBandwidth) &&
+		(r.MaxHeight == 0 || v.height <= r.MaxHeight)
+}
+
+func newVariant(attributes []attribute, lines ...string) variant {
+	v := variant{lines: lines}
+	v.bandwidth, _ = strconv.Atoi(getAttribute(attributes, "BANDWIDTH"))
+
+	resolution := strings.SplitN(getAttribute(attributes, "RESOLUTION"), "x", 2)
+	if len(resolution) == 2 {
+		v.height, _ = strconv.Atoi(resolution[1])
+	}
+
+	return v
+}
+
+// Rewrites playlist according to rules, variant rules apply to master playlists only.
+func RewritePlaylist(playlist string, rules Rules) string {
+	if rules.empty() {
+		return playlist
+	}
+
+	stripTags := map[string]bool{}
+	for _, tag := range rules.StripTags {
+		stripTags[strings.ToUpper(strings.TrimSpace(tag))] = true
+	}
+
+	lines := []string{}
+	variants := []variant{}
+	iframes := []variant{}
+	variantsAt, iframesAt := -1, -1
+
+	// whether default audio language is available
+	hasDefaultAudio := false
+
+	scanner := bufio.NewScanner(strings.NewReader(playlist))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		name, attributes := parseTag(line)
+
+		if strings.HasPrefix(line, "#") && stripTags[strings.ToUpper(name)] {
+			continue
+		}
+
+		switch name {
+		case "#EXTM3U":
+			lines = append(lines, line)
+			lines = append(lines, rules.Inject...)
+		case "#EXT-X-STREAM-INF":
+			// uri is on the next line
+			uri := ""
+			for scanner.Scan() {
+				uri = strings.TrimSpace(scanner.Text())
+				if uri != "" {
+					break
+				}
+			}
+
+			if variantsAt == -1 {
+				variantsAt = len(lines)
+			}
+			variants = append(variants, newVariant(attributes, line, uri))
+		case "#EXT-X-I-FRAME-STREAM-INF":
+			if iframesAt == -1 {
+				iframesAt = len(lines)
+			}
+			iframes = append(iframes, newVariant(attributes, line))
+		case "#EXT-X-MEDIA":
+			if rules.DefaultAudio != "" && getAttribute(attributes, "TYPE") == "AUDIO" &&
+				matchLanguage(getAttribute(attributes, "LANGUAGE"), rules.DefaultAudio) {
+				hasDefaultAudio = true
+			}
+			lines = append(lines, line)
+		default:
+			lines = append(lines, line)
+		}
+	}
+
+	if rules.DefaultAudio != "" && hasDefaultAudio {
+		for i, line := range lines {
+			name, attributes := parseTag(line)
+			if name != "#EXT-X-MEDIA" || getAttribute(attributes, "TYPE") != "AUDIO" {
+				continue
+			}
+
+			if matchLanguage(getAttribute(attributes, "LANGUAGE"), rules.DefaultAudio) {
+				attributes = setAttribute(attributes, "DEFAULT", "YES")
+				attributes = setAttribute(attributes, "AUTOSELECT", "YES")
+			} else {
+				attributes = setAttribute(attributes, "DEFAULT", "NO")
+			}
+			lines[i] = formatTag(name, attributes)
+		}
+	}
+
+	// insert variants back, later position first so that earlier stays valid,
+	// i-frame variants go after stream variants at the same position
+	type group struct {
+		at       int
+		variants []variant
+	}
+	groups := []group{{variantsAt, rules.variants(variants)}, {iframesAt, rules.variants(iframes)}}
+	if iframesAt >= variantsAt {
+		groups[0], groups[1] = groups[1], groups[0]
+	}
+
+	for _, g := range groups {
+		if g.at == -1 {
+			continue
+		}
+
+		inserted := []string{}
+		for _, v := range g.variants {
+			inserted = append(inserted, v.lines...)
+		}
+
+		lines = append(lines[:g.at], append(inserted, lines[g.at:]...)...)
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// filter and order variants according to rules
+func (r Rules) variants(variants []variant) []variant {
+	filtered := []variant{}
+	for _, v := range variants {
+		if r.allowed(v) {
+			filtered = append(filtered, v)
+		}
+	}
+
+	// never return empty master playlist, keep the lowest variant
+	if len(filtered) == 0 && len(variants) > 0 {
+		lowest := variants[0]
+		for _, v := range variants {
+			if v.bandwidth < lowest.bandwidth {
+				lowest = v
+			}
+		}
+		filtered = append(filtered, lowest)
+	}
+
+	switch r.Order {
+	case "asc":
+		sort.SliceStable(filtered, func(i, j int) bool { return filtered[i].bandwidth < filtered[j].bandwidth })
+	case "desc":
+		sort.SliceStable(filtered, func(i, j int) bool { return filtered[i].bandwidth > filtered[j].bandwidth })
+	}
+
+	return filtered
+}
+
+// language matches exactly or by primary subtag, e.g. en matches en-US
+func matchLanguage(language, want string) bool {
+	language, want = strings.ToLower(language), strings.ToLower(want)
+	return language == want || strings.HasPrefix(language, want+"-")
+}
@@ -0,0 +1,89 @@
+package hlsproxy
+
+import (
+	"testing"
+)
+
+func TestRewritePlaylist(t *testing.T) {
+	master := `#EXTM3U
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aud",LANGUAGE="de",NAME="Deutsch",DEFAULT=YES,URI="de.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aud",LANGUAGE="en-US",NAME="English",URI="en.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=14000000,RESOLUTION=3840x2160,CODECS="hvc1.2.4.L150,mp4a.40.2",AUDIO="aud"
+2160p.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360,AUDIO="aud"
+360p.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=5000000,RESOLUTION=1920x1080,AUDIO="aud"
+1080p.m3u8
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=1400000,RESOLUTION=3840x2160,URI="2160p_iframes.m3u8"
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=100000,RESOLUTION=640x360,URI="360p_iframes.m3u8"
+`
+
+	tests := []struct {
+		name     string
+		playlist string
+		rules    Rules
+		want     string
+	}{
+		{
+			name:     "filter, order, default audio and inject",
+			playlist: master,
+			rules: Rules{
+				MaxHeight:    1080,
+				Order:        "desc",
+				DefaultAudio: "en",
+				Inject:       []string{"#EXT-X-INDEPENDENT-SEGMENTS"},
+			},
+			want: `#EXTM3U
+#EXT-X-INDEPENDENT-SEGMENTS
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aud",LANGUAGE="de",NAME="Deutsch",DEFAULT=NO,URI="de.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aud",LANGUAGE="en-US",NAME="English",URI="en.m3u8",DEFAULT=YES,AUTOSELECT=YES
+#EXT-X-STREAM-INF:BANDWIDTH=5000000,RESOLUTION=1920x1080,AUDIO="aud"
+1080p.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360,AUDIO="aud"
+360p.m3u8
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=100000,RESOLUTION=640x360,URI="360p_iframes.m3u8"
+`,
+		},
+		{
+			name:     "keep lowest variant",
+			playlist: master,
+			rules:    Rules{MaxBandwidth: 1000, DefaultAudio: "fr"},
+			want: `#EXTM3U
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aud",LANGUAGE="de",NAME="Deutsch",DEFAULT=YES,URI="de.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aud",LANGUAGE="en-US",NAME="English",URI="en.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360,AUDIO="aud"
+360p.m3u8
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=100000,RESOLUTION=640x360,URI="360p_iframes.m3u8"
+`,
+		},
+		{
+			name: "strip tags from media playlist",
+			playlist: `#EXTM3U
+#EXT-X-TARGETDURATION:6
+#EXT-X-DATERANGE:ID="ad1",START-DATE="2020-01-01T00:00:00Z"
+#EXT-X-CUE-OUT:DURATION=30
+#EXTINF:6.000,
+seg_1.ts?token=a=b
+#EXT-X-CUE-IN
+#EXTINF:6.000,
+seg_2.ts
+`,
+			rules: Rules{StripTags: []string{"#EXT-X-DATERANGE", "#ext-x-cue-out", "#EXT-X-CUE-IN"}},
+			want: `#EXTM3U
+#EXT-X-TARGETDURATION:6
+#EXTINF:6.000,
+seg_1.ts?token=a=b
+#EXTINF:6.000,
+seg_2.ts
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RewritePlaylist(tt.playlist, tt.rules); got != tt.want {
+				t.Errorf("RewritePlaylist() = \n%v\nwant\n%v", got, tt.want)
+			}
+		})
+	}
+}
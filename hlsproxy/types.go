@@ -1,10 +1,79 @@
 package hlsproxy
 
-import "net/http"
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type Config struct {
+	BaseUrl string   // Upstream base url.
+	Mirrors []string // Upstream base urls used, in order, when primary is unhealthy.
+	Prefix  string   // Path prefix under which is proxy served.
+	Cache   Cache    // If nil, unlimited memory cache will be used.
+
+	RangeCacheLimit int64 // Larger resources are not cached whole for range requests, defaults to 64MB.
+
+	Headers  map[string]string // Additional upstream request headers, e.g. Referer, User-Agent or Cookie.
+	Query    url.Values        // Additional upstream query parameters.
+	Username string            // Basic auth username.
+	Password string            // Basic auth password.
+	Token    string            // Bearer token.
+
+	Timeout            time.Duration // Upstream request timeout, defaults to 30s.
+	Retries            int           // Number of retries of failed upstream request.
+	RetryDelay         time.Duration // Delay before first retry, doubled with each attempt, defaults to 250ms.
+	InsecureSkipVerify bool          // Skip TLS certificate verification.
+	ProxyUrl           string        // HTTP proxy used for upstream requests.
+
+	RedirectCheck func(u *url.URL) error             // Reject upstream redirects, if set.
+	DialCheck     func(host string, ip net.IP) error // Reject upstream addresses, checked on every connection, if set.
+
+	KeyExpiration time.Duration              // How long are upstream keys kept in memory, defaults to 5m.
+	Decrypt       bool                       // Serve AES-128 segments decrypted.
+	Reencrypt     bool                       // Serve segments encrypted by our own key.
+	ReencryptKey  []byte                     // Our own 16 byte key, random if not set.
+	KeyAuth       func(r *http.Request) bool // Authorize requests for our own key, if set.
+	KeyAuthQuery  string                     // Playlist query parameter passed to our key uri instead of upstream, e.g. token.
+
+	Rules    Rules     // Playlist rewriting rules.
+	Variants []Variant // Additional variants appended to master playlists.
+
+	Prefetch            int           // Number of upcoming segments to prefetch, 0 disables prefetching.
+	PrefetchConcurrency int           // Maximum of parallel prefetch downloads, defaults to 2.
+	PrefetchBandwidth   int64         // Prefetch bandwidth cap in bytes per second, 0 means unlimited.
+	PrefetchIdle        time.Duration // Stop prefetching when playlist was not requested for this long, defaults to 30s.
+}
+
+// Variant is advertised in master playlist, e.g. our own transcoded rendition.
+type Variant struct {
+	Uri        string
+	Bandwidth  int    // bits per second
+	Resolution string // e.g. 1280x720, optional
+	Codecs     string // optional
+}
 
 type Manager interface {
 	Shutdown()
 
 	ServePlaylist(w http.ResponseWriter, r *http.Request)
+	ServeManifest(w http.ResponseWriter, r *http.Request)
 	ServeMedia(w http.ResponseWriter, r *http.Request)
 }
+
+// Cache stores proxied resources, it can be shared by multiple managers.
+type Cache interface {
+	Get(key string) (CacheEntry, bool)
+	Set(key string, expires time.Time) (CacheEntry, error)
+	RemoveEntry(key string, entry CacheEntry) // only if key still holds entry
+	Cleanup() int                             // returns number of remaining entries
+}
+
+// CacheEntry can be served while it is still being written.
+type CacheEntry interface {
+	io.WriteCloser
+	Len() int
+	ServeHTTP(w http.ResponseWriter)
+}
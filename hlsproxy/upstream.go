@@ -0,0 +1,171 @@
+package hlsproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// default delay before first retry, doubled with each attempt
+const defaultRetryDelay = 250 * time.Millisecond
+
+// how long is failed upstream skipped in favour of healthy ones
+const upstreamCooldown = 30 * time.Second
+
+type upstream struct {
+	baseUrl       string
+	unhealthyTill time.Time
+}
+
+type upstreamsCtx struct {
+	mu   sync.Mutex
+	list []*upstream // primary first, then mirrors in configured order
+}
+
+func newUpstreams(baseUrls []string) *upstreamsCtx {
+	list := make([]*upstream, len(baseUrls))
+	for i, baseUrl := range baseUrls {
+		list[i] = &upstream{baseUrl: baseUrl}
+	}
+	return &upstreamsCtx{list: list}
+}
+
+// healthy upstreams in configured order, followed by unhealthy ones
+func (u *upstreamsCtx) ordered() []*upstream {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now()
+	healthy := []*upstream{}
+	unhealthy := []*upstream{}
+	for _, up := range u.list {
+		if now.Before(up.unhealthyTill) {
+			unhealthy = append(unhealthy, up)
+		} else {
+			healthy = append(healthy, up)
+		}
+	}
+
+	return append(healthy, unhealthy...)
+}
+
+func (u *upstreamsCtx) mark(up *upstream, healthy bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if healthy {
+		up.unhealthyTill = time.Time{}
+	} else {
+		up.unhealthyTill = time.Now().Add(upstreamCooldown)
+	}
+}
+
+type httpStatusError struct {
+	code int
+}
+
+func (e *httpStatusError) Error() string {
+	return "unexpected HTTP status: " + http.StatusText(e.code)
+}
+
+// whether request should be retried, or sent to another upstream
+func retryable(err error) bool {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.code >= 500 ||
+			statusErr.code == http.StatusRequestTimeout ||
+			statusErr.code == http.StatusTooManyRequests
+	}
+
+	// cancelled by caller
+	return !errors.Is(err, context.Canceled)
+}
+
+// get resource from first healthy upstream, url must start with primary base url.
+// Returns successful response and base url of upstream that served it.
+func (m *ManagerCtx) upstreamGet(ctx context.Context, url string, header http.Header) (*http.Response, string, error) {
+	path := strings.TrimPrefix(url, m.baseUrl)
+
+	var lastErr error
+	for _, up := range m.upstreams.ordered() {
+		resp, err := m.retryGet(ctx, up.baseUrl+path, header)
+		if err == nil {
+			m.upstreams.mark(up, true)
+			return resp, up.baseUrl, nil
+		}
+
+		lastErr = err
+		if !retryable(err) {
+			return nil, "", err
+		}
+
+		m.logger.Warn().Err(err).Str("upstream", up.baseUrl).Msg("upstream failed")
+		m.upstreams.mark(up, false)
+	}
+
+	return nil, "", lastErr
+}
+
+// get resource, retry with exponential backoff and jitter on transient errors
+func (m *ManagerCtx) retryGet(ctx context.Context, url string, header http.Header) (*http.Response, error) {
+	delay := m.config.RetryDelay
+	if delay <= 0 {
+		delay = defaultRetryDelay
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := m.httpGet(ctx, url, header)
+		if err == nil && (resp.StatusCode < 200 || resp.StatusCode >= 300) {
+			// read all response body
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+
+			err = &httpStatusError{resp.StatusCode}
+		}
+
+		if err == nil {
+			return resp, nil
+		}
+
+		if attempt >= m.config.Retries || !retryable(err) {
+			return nil, err
+		}
+
+		m.logger.Debug().Err(err).Str("url", url).Int("attempt", attempt+1).Msg("retrying upstream request")
+
+		// add up to 50% of jitter
+		wait := delay + time.Duration(rand.Int63n(int64(delay)/2+1))
+		delay *= 2
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// respond with status matching upstream error
+func (m *ManagerCtx) upstreamError(w http.ResponseWriter, err error) {
+	code := http.StatusBadGateway
+
+	var statusErr *httpStatusError
+	var netErr net.Error
+	if errors.As(err, &statusErr) {
+		// client errors are propagated, server errors are bad gateway
+		if statusErr.code < 500 {
+			code = statusErr.code
+		}
+	} else if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		code = http.StatusGatewayTimeout
+	}
+
+	m.logger.Err(err).Int("code", code).Msg("unable to get upstream resource")
+	http.Error(w, http.StatusText(code), code)
+}
@@ -0,0 +1,39 @@
+package hlsproxy
+
+import (
+	"fmt"
+	"strings"
+)
+
+func (v Variant) streamInf() string {
+	streamInf := fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d", v.Bandwidth)
+	if v.Resolution != "" {
+		streamInf += ",RESOLUTION=" + v.Resolution
+	}
+	if v.Codecs != "" {
+		streamInf += ",CODECS=\"" + v.Codecs + "\""
+	}
+	return streamInf
+}
+
+// Appends variants to master playlist, media playlists are returned unchanged.
+func AppendVariants(playlist string, variants []Variant) string {
+	if len(variants) == 0 || !strings.Contains(playlist, "#EXT-X-STREAM-INF:") {
+		return playlist
+	}
+
+	var sb strings.Builder
+	sb.WriteString(playlist)
+	if !strings.HasSuffix(playlist, "\n") {
+		sb.WriteRune('\n')
+	}
+
+	for _, variant := range variants {
+		sb.WriteString(variant.streamInf())
+		sb.WriteRune('\n')
+		sb.WriteString(variant.Uri)
+		sb.WriteRune('\n')
+	}
+
+	return sb.String()
+}
@@ -2,6 +2,7 @@ package hlsvod
 
 import (
 	"crypto/sha1"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path"
@@ -18,12 +19,7 @@ func (m *ManagerCtx) getCacheData() ([]byte, error) {
 	}
 
 	// check for global cache
-	h := sha1.New()
-	h.Write([]byte(m.config.MediaPath))
-	hash := h.Sum(nil)
-
-	fileName := fmt.Sprintf("%x%s", hash, cacheFileSuffix)
-	globalCachePath := path.Join(m.config.CacheDir, fileName)
+	globalCachePath := getGlobalCachePath(m.config.MediaPath, m.config.CacheDir)
 	if _, err := os.Stat(globalCachePath); err == nil {
 		m.logger.Info().Str("path", globalCachePath).Msg("media global cache hit")
 		return os.ReadFile(globalCachePath)
@@ -38,11 +34,39 @@ func (m *ManagerCtx) saveLocalCacheData(data []byte) error {
 }
 
 func (m *ManagerCtx) saveGlobalCacheData(data []byte) error {
+	globalCachePath := getGlobalCachePath(m.config.MediaPath, m.config.CacheDir)
+	return os.WriteFile(globalCachePath, data, 0755)
+}
+
+func getGlobalCachePath(mediaPath string, cacheDir string) string {
 	h := sha1.New()
-	h.Write([]byte(m.config.MediaPath))
+	h.Write([]byte(mediaPath))
 	hash := h.Sum(nil)
 
 	fileName := fmt.Sprintf("%x%s", hash, cacheFileSuffix)
-	globalCachePath := path.Join(m.config.CacheDir, fileName)
-	return os.WriteFile(globalCachePath, data, 0755)
+	return path.Join(cacheDir, fileName)
+}
+
+// CachedMetadata returns media metadata from local or global cache, without probing media.
+func CachedMetadata(mediaPath string, cacheDir string) (*ProbeMediaData, error) {
+	paths := []string{mediaPath + cacheFileSuffix}
+	if cacheDir != "" {
+		paths = append(paths, getGlobalCachePath(mediaPath, cacheDir))
+	}
+
+	for _, cachePath := range paths {
+		data, err := os.ReadFile(cachePath)
+		if err != nil {
+			continue
+		}
+
+		var metadata ProbeMediaData
+		if err := json.Unmarshal(data, &metadata); err != nil {
+			return nil, err
+		}
+
+		return &metadata, nil
+	}
+
+	return nil, os.ErrNotExist
 }
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http"
+	"path"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/m1k1o/go-transcode/hlsproxy"
+)
+
+const dashProxyPerfix = "/dashproxy/"
+
+var dashProxyManagers map[string]hlsproxy.Manager = make(map[string]hlsproxy.Manager)
+
+func (a *ApiManagerCtx) DashProxy(r chi.Router) {
+	a.hlsProxyCacheInit()
+
+	r.Get(dashProxyPerfix+"{sourceId}/*", func(w http.ResponseWriter, r *http.Request) {
+		ID := chi.URLParam(r, "sourceId")
+
+		// check if stream exists
+		conf, ok := a.config.DashProxy[ID]
+		if !ok {
+			http.Error(w, "404 dash proxy source not found", http.StatusNotFound)
+			return
+		}
+
+		hlsProxyManagersMu.Lock()
+		manager, ok := dashProxyManagers[ID]
+		if !ok {
+			// same upstream handling and cache as hls proxy
+			config := a.hlsProxyConfig(dashProxyPerfix+ID+"/", conf)
+
+			// keys are handled only in hls playlists, dash segments are served as they are
+			config.Decrypt, config.Reencrypt = false, false
+
+			manager = hlsproxy.New(config)
+			dashProxyManagers[ID] = manager
+		}
+		hlsProxyManagersMu.Unlock()
+
+		// if this is manifest request
+		if path.Ext(r.URL.Path) == ".mpd" {
+			manager.ServeManifest(w, r)
+		} else {
+			manager.ServeMedia(w, r)
+		}
+	})
+}
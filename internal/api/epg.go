@@ -0,0 +1,521 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// timeout for connecting to EPG sources and receiving headers,
+// body is not limited, as guides can be large
+const epgTimeout = 60 * time.Second
+
+// number of Enigma2 services whose EPG is requested at once
+const epgEnigma2Concurrency = 4
+
+// XMLTV date format
+const xmltvTimeFormat = "20060102150405 -0700"
+
+// programmes of all channels, channels are added when serving
+var epgData *xmltv
+var epgDataMu sync.RWMutex
+
+type xmltvText struct {
+	Lang  string `xml:"lang,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+type xmltvIcon struct {
+	Src string `xml:"src,attr"`
+}
+
+type xmltvChannel struct {
+	Id           string      `xml:"id,attr"`
+	DisplayNames []xmltvText `xml:"display-name"`
+	Icon         *xmltvIcon  `xml:"icon,omitempty"`
+}
+
+type xmltvProgramme struct {
+	Start   string `xml:"start,attr"`
+	Stop    string `xml:"stop,attr,omitempty"`
+	Channel string `xml:"channel,attr"`
+	Inner   string `xml:",innerxml"` // passed through as is
+}
+
+type xmltv struct {
+	XMLName       xml.Name         `xml:"tv"`
+	GeneratorName string           `xml:"generator-info-name,attr,omitempty"`
+	Channels      []xmltvChannel   `xml:"channel"`
+	Programmes    []xmltvProgramme `xml:"programme"`
+}
+
+type enigma2Event struct {
+	Start               int64  `xml:"e2eventstart"`
+	Duration            int64  `xml:"e2eventduration"`
+	Title               string `xml:"e2eventtitle"`
+	Description         string `xml:"e2eventdescription"`
+	DescriptionExtended string `xml:"e2eventdescriptionextended"`
+	Reference           string `xml:"e2eventservicereference"`
+}
+
+type enigma2EventList struct {
+	XMLName xml.Name       `xml:"e2eventlist"`
+	Events  []enigma2Event `xml:"e2event"`
+}
+
+func (a *ApiManagerCtx) epgEnabled() bool {
+	return len(a.config.EPG.Sources) > 0 || (a.config.EPG.Enigma2 && a.config.Enigma2.WebifUrl != "")
+}
+
+func (a *ApiManagerCtx) EPG(r chi.Router) {
+	r.Get("/epg.xml", func(w http.ResponseWriter, r *http.Request) {
+		epgDataMu.RLock()
+		data := epgData
+		epgDataMu.RUnlock()
+
+		if data == nil {
+			http.Error(w, "503 epg not loaded yet", http.StatusServiceUnavailable)
+			return
+		}
+
+		// logos can be served by us, their urls depend on request
+		tv := *data
+		tv.Channels = a.epgChannels(requestBaseUrl(r, a.config.Proxy))
+
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = w.Write([]byte(xml.Header))
+
+		enc := xml.NewEncoder(w)
+		enc.Indent("", "  ")
+		if err := enc.Encode(tv); err != nil {
+			log.Warn().Err(err).Str("module", "epg").Msg("unable to write epg")
+		}
+	})
+}
+
+// channels of all streams, with their logos
+func (a *ApiManagerCtx) epgChannels(baseUrl string) []xmltvChannel {
+	channels := []xmltvChannel{}
+	for _, id := range a.config.StreamIDs() {
+		meta := a.config.StreamMeta(id)
+
+		name := meta.Name
+		if name == "" {
+			name = id
+		}
+
+		channel := xmltvChannel{
+			Id:           id,
+			DisplayNames: []xmltvText{{Value: name}},
+		}
+		if logo := a.streamLogoUrl(baseUrl, id, meta); logo != "" {
+			channel.Icon = &xmltvIcon{Src: logo}
+		}
+
+		channels = append(channels, channel)
+	}
+
+	return channels
+}
+
+// periodically rebuild EPG from all sources
+func (a *ApiManagerCtx) epgRefresh(shutdown chan struct{}) {
+	logger := log.With().Str("module", "epg").Logger()
+
+	ticker := time.NewTicker(a.config.EPG.Refresh)
+	defer ticker.Stop()
+
+	for {
+		data, err := a.epgBuild()
+		if err != nil {
+			// previous EPG is kept on error
+			logger.Warn().Err(err).Msg("unable to build epg")
+		} else {
+			epgDataMu.Lock()
+			epgData = data
+			epgDataMu.Unlock()
+		}
+
+		select {
+		case <-shutdown:
+			return
+		case <-ticker.C:
+		case <-a.epgRebuild:
+		}
+	}
+}
+
+// rebuild EPG as soon as possible, pending request is not repeated
+func (a *ApiManagerCtx) epgRebuildRequest() {
+	if !a.epgEnabled() {
+		return
+	}
+
+	select {
+	case a.epgRebuild <- struct{}{}:
+	default:
+	}
+}
+
+// merge Enigma2 EPG and XMLTV sources, keyed by stream IDs
+func (a *ApiManagerCtx) epgBuild() (*xmltv, error) {
+	logger := log.With().Str("module", "epg").Logger()
+
+	programmes := []xmltvProgramme{}
+
+	// external sources are preferred, as they usually contain more details
+	for _, source := range a.config.EPG.Sources {
+		tv, err := epgLoadXmltv(source, a.config.AbsPath)
+		if err != nil {
+			logger.Warn().Err(err).Str("source", source).Msg("unable to load xmltv")
+			continue
+		}
+
+		programmes = append(programmes, a.epgMapXmltv(tv)...)
+	}
+
+	if a.config.EPG.Enigma2 && a.config.Enigma2.WebifUrl != "" {
+		events, err := a.epgEnigma2()
+		if err != nil {
+			logger.Warn().Err(err).Msg("unable to get enigma2 epg")
+		}
+
+		// times of the same programme can differ between sources, do not mix them
+		xmltvChannels := map[string]struct{}{}
+		for _, p := range programmes {
+			xmltvChannels[p.Channel] = struct{}{}
+		}
+
+		for _, p := range events {
+			if _, ok := xmltvChannels[p.Channel]; !ok {
+				programmes = append(programmes, p)
+			}
+		}
+	}
+
+	// remove duplicates, first one wins
+	seen := map[string]struct{}{}
+	unique := []xmltvProgramme{}
+	for _, p := range programmes {
+		// the same time can be written in different timezones
+		key := p.Channel + "@" + p.Start
+		if start := epgTime(p.Start); !start.IsZero() {
+			key = p.Channel + "@" + strconv.FormatInt(start.Unix(), 10)
+		}
+
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		unique = append(unique, p)
+	}
+
+	sort.SliceStable(unique, func(i, j int) bool {
+		if unique[i].Channel != unique[j].Channel {
+			return unique[i].Channel < unique[j].Channel
+		}
+		return epgTime(unique[i].Start).Before(epgTime(unique[j].Start))
+	})
+
+	logger.Info().Int("programmes", len(unique)).Msg("epg built")
+	return &xmltv{
+		GeneratorName: "go-transcode",
+		Programmes:    unique,
+	}, nil
+}
+
+// map XMLTV channels to stream IDs by tvg-id, stream ID or display name
+func (a *ApiManagerCtx) epgMapXmltv(tv xmltv) []xmltvProgramme {
+	byTvgId := map[string]string{}
+	byName := map[string]string{}
+	for _, id := range a.config.StreamIDs() {
+		meta := a.config.StreamMeta(id)
+		if meta.TvgId != "" {
+			byTvgId[meta.TvgId] = id
+		}
+		byTvgId[id] = id
+		if meta.Name != "" {
+			byName[strings.ToLower(meta.Name)] = id
+		}
+	}
+
+	channels := map[string]string{}
+	for _, channel := range tv.Channels {
+		if id, ok := byTvgId[channel.Id]; ok {
+			channels[channel.Id] = id
+			continue
+		}
+
+		for _, name := range channel.DisplayNames {
+			if id, ok := byName[strings.ToLower(strings.TrimSpace(name.Value))]; ok {
+				channels[channel.Id] = id
+				break
+			}
+		}
+	}
+
+	// programmes of unknown channels are dropped
+	programmes := []xmltvProgramme{}
+	for _, p := range tv.Programmes {
+		id, ok := channels[p.Channel]
+		if !ok {
+			if id, ok = byTvgId[p.Channel]; !ok {
+				continue
+			}
+		}
+
+		p.Channel = id
+		programmes = append(programmes, p)
+	}
+
+	return programmes
+}
+
+// get current events of bouquet and schedule of each imported service
+func (a *ApiManagerCtx) epgEnigma2() ([]xmltvProgramme, error) {
+	webifUrl, err := url.Parse(a.config.Enigma2.WebifUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	streams := map[string]string{} // reference -> stream ID
+	bouquets := map[string]struct{}{}
+	for _, id := range a.config.StreamIDs() {
+		if meta := a.config.StreamMeta(id); meta.Reference != "" {
+			streams[meta.Reference] = id
+			if meta.Bouquet != "" {
+				bouquets[meta.Bouquet] = struct{}{}
+			}
+		}
+	}
+
+	events := []enigma2Event{}
+	var lastErr error
+
+	for ref := range bouquets {
+		now, err := enigma2Events(webifUrl, "/web/epgnow", url.Values{"bRef": {ref}})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		events = append(events, now...)
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, epgEnigma2Concurrency)
+
+	for ref := range streams {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(ref string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			schedule, err := enigma2Events(webifUrl, "/web/epgservice", url.Values{"sRef": {ref}})
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				lastErr = err
+				return
+			}
+			events = append(events, schedule...)
+		}(ref)
+	}
+	wg.Wait()
+
+	programmes := []xmltvProgramme{}
+	for _, event := range events {
+		id, ok := streams[event.Reference]
+		if !ok || event.Start == 0 || event.Title == "" {
+			continue
+		}
+
+		start := time.Unix(event.Start, 0)
+		stop := start.Add(time.Duration(event.Duration) * time.Second)
+
+		var inner bytes.Buffer
+		inner.WriteString("<title>")
+		_ = xml.EscapeText(&inner, []byte(event.Title))
+		inner.WriteString("</title>")
+
+		if event.Description != "" {
+			inner.WriteString("<sub-title>")
+			_ = xml.EscapeText(&inner, []byte(event.Description))
+			inner.WriteString("</sub-title>")
+		}
+
+		if event.DescriptionExtended != "" {
+			inner.WriteString("<desc>")
+			_ = xml.EscapeText(&inner, []byte(event.DescriptionExtended))
+			inner.WriteString("</desc>")
+		}
+
+		programmes = append(programmes, xmltvProgramme{
+			Start:   start.Format(xmltvTimeFormat),
+			Stop:    stop.Format(xmltvTimeFormat),
+			Channel: id,
+			Inner:   inner.String(),
+		})
+	}
+
+	return programmes, lastErr
+}
+
+func enigma2Events(webifUrl *url.URL, endpoint string, query url.Values) ([]enigma2Event, error) {
+	apiUrl := *webifUrl
+	apiUrl.Path = path.Join(apiUrl.Path, endpoint)
+	apiUrl.RawQuery = query.Encode()
+
+	body, err := epgGet(apiUrl.String())
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var list enigma2EventList
+	if err := xml.NewDecoder(body).Decode(&list); err != nil {
+		return nil, err
+	}
+
+	return list.Events, nil
+}
+
+// get XMLTV from http url or local file, relative to base dir
+func epgLoadXmltv(source string, absPath func(elem ...string) string) (xmltv, error) {
+	var body io.ReadCloser
+	var err error
+
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		body, err = epgGet(source)
+	} else {
+		if !strings.HasPrefix(source, "/") {
+			source = absPath(source)
+		}
+		body, err = os.Open(source)
+	}
+
+	if err != nil {
+		return xmltv{}, err
+	}
+	defer body.Close()
+
+	var tv xmltv
+	decoder := xml.NewDecoder(body)
+	// besides UTF-8, XMLTV files are commonly in latin1
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		switch strings.ToLower(charset) {
+		case "iso-8859-1", "latin1":
+			return &latin1Reader{r: bufio.NewReader(input)}, nil
+		}
+		return nil, fmt.Errorf("unsupported charset %s", charset)
+	}
+
+	err = decoder.Decode(&tv)
+	return tv, err
+}
+
+// converts latin1 to UTF-8
+type latin1Reader struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+func (l *latin1Reader) Read(p []byte) (int, error) {
+	for len(l.buf) < len(p) {
+		c, err := l.r.ReadByte()
+		if err != nil {
+			if len(l.buf) > 0 {
+				break
+			}
+			return 0, err
+		}
+		l.buf = append(l.buf, string(rune(c))...)
+	}
+
+	n := copy(p, l.buf)
+	l.buf = l.buf[n:]
+	return n, nil
+}
+
+func epgGet(url string) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// only connecting and waiting for headers is timed out
+	timer := time.AfterFunc(epgTimeout, cancel)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil || !timer.Stop() {
+		cancel()
+		if err == nil {
+			resp.Body.Close()
+			err = context.DeadlineExceeded
+		}
+		return nil, fmt.Errorf("http get error: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("status error: %d", resp.StatusCode)
+	}
+
+	return &epgBody{resp.Body, cancel}, nil
+}
+
+// releases request context when body is closed
+type epgBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *epgBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// parse XMLTV time, used for sorting
+func epgTime(value string) time.Time {
+	if t, err := time.Parse(xmltvTimeFormat, value); err == nil {
+		return t
+	}
+
+	// time without timezone, or only partial time
+	digits := value
+	if i := strings.Index(digits, " "); i >= 0 {
+		digits = digits[:i]
+	}
+	if _, err := strconv.ParseInt(digits, 10, 64); err == nil && len(digits) <= 14 {
+		digits += strings.Repeat("0", 14-len(digits))
+		t, _ := time.Parse("20060102150405", digits)
+		return t
+	}
+
+	return time.Time{}
+}
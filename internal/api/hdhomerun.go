@@ -0,0 +1,374 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// SSDP multicast group
+const ssdpAddr = "239.255.255.250:1900"
+
+// device types answered by SSDP
+const hdhomerunDeviceType = "urn:schemas-upnp-org:device:MediaServer:1"
+
+// lineup channels are served under this path, limited by tuners
+const hdhomerunPrefix = "/hdhomerun/"
+
+// guide numbers derived from stream IDs are in range 10000-99999
+const hdhomerunGuideNumberBase = 10000
+const hdhomerunGuideNumberCount = 90000
+
+// guide numbers, that can be advertised as they are, e.g. 5 or 5.1
+var hdhomerunGuideNumberRegex = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?$`)
+
+// number of lineup channels being watched
+var hdhomerunSessions int
+var hdhomerunSessionsMu sync.Mutex
+
+type hdhomerunDiscover struct {
+	FriendlyName    string
+	Manufacturer    string
+	ModelNumber     string
+	FirmwareName    string
+	FirmwareVersion string
+	DeviceID        string
+	DeviceAuth      string
+	BaseURL         string
+	LineupURL       string
+	TunerCount      int
+}
+
+type hdhomerunLineupStatus struct {
+	ScanInProgress int
+	ScanPossible   int
+	Source         string
+	SourceList     []string
+}
+
+type hdhomerunChannel struct {
+	GuideNumber string
+	GuideName   string
+	URL         string
+}
+
+type hdhomerunDevice struct {
+	XMLName     xml.Name `xml:"urn:schemas-upnp-org:device-1-0 root"`
+	SpecVersion struct {
+		Major int `xml:"major"`
+		Minor int `xml:"minor"`
+	} `xml:"specVersion"`
+	URLBase string `xml:"URLBase"`
+	Device  struct {
+		DeviceType   string `xml:"deviceType"`
+		FriendlyName string `xml:"friendlyName"`
+		Manufacturer string `xml:"manufacturer"`
+		ModelName    string `xml:"modelName"`
+		ModelNumber  string `xml:"modelNumber"`
+		SerialNumber string `xml:"serialNumber"`
+		UDN          string `xml:"UDN"`
+	} `xml:"device"`
+}
+
+func (a *ApiManagerCtx) HDHomeRun(r chi.Router) {
+	r.Get("/discover.json", func(w http.ResponseWriter, r *http.Request) {
+		baseUrl := requestBaseUrl(r, a.config.Proxy)
+		conf := a.config.HDHomeRun
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(hdhomerunDiscover{
+			FriendlyName:    conf.FriendlyName,
+			Manufacturer:    "Silicondust",
+			ModelNumber:     "HDTC-2US",
+			FirmwareName:    "hdhomeruntc_atsc",
+			FirmwareVersion: "20150826",
+			DeviceID:        conf.DeviceID,
+			DeviceAuth:      "go-transcode",
+			BaseURL:         baseUrl,
+			LineupURL:       baseUrl + "/lineup.json",
+			TunerCount:      conf.Tuners,
+		})
+	})
+
+	r.Get("/lineup_status.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(hdhomerunLineupStatus{
+			ScanInProgress: 0,
+			ScanPossible:   1,
+			Source:         "Cable",
+			SourceList:     []string{"Cable"},
+		})
+	})
+
+	// channel scan is requested by Plex, lineup is always up to date
+	r.Post("/lineup.post", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	r.Get("/lineup.json", func(w http.ResponseWriter, r *http.Request) {
+		baseUrl := requestBaseUrl(r, a.config.Proxy)
+
+		// DVR clients expect video channels only
+		ids := []string{}
+		for _, id := range a.config.StreamIDs() {
+			if !a.config.StreamMeta(id).Radio && streamIdRegex.MatchString(id) {
+				ids = append(ids, id)
+			}
+		}
+
+		numbers := a.hdhomerunGuideNumbers(ids)
+
+		lineup := []hdhomerunChannel{}
+		for _, id := range ids {
+			name := a.config.StreamMeta(id).Name
+			if name == "" {
+				name = id
+			}
+
+			lineup = append(lineup, hdhomerunChannel{
+				GuideNumber: numbers[id],
+				GuideName:   name,
+				URL:         baseUrl + hdhomerunPrefix + url.PathEscape(id),
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(lineup)
+	})
+
+	r.Get("/device.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = w.Write(a.hdhomerunDeviceXml(requestBaseUrl(r, a.config.Proxy)))
+	})
+
+	// lineup channel transcoded by configured profile, clients are told how many tuners we have
+	r.Get(hdhomerunPrefix+"{input}", func(w http.ResponseWriter, r *http.Request) {
+		if !hdhomerunSessionAcquire(a.config.HDHomeRun.Tuners) {
+			w.Header().Set("Retry-After", "30")
+			http.Error(w, "503 all tuners are in use", http.StatusServiceUnavailable)
+			return
+		}
+		defer hdhomerunSessionRelease()
+
+		a.httpStream(w, r, a.config.HDHomeRun.Profile)
+	})
+}
+
+func hdhomerunSessionAcquire(tuners int) bool {
+	hdhomerunSessionsMu.Lock()
+	defer hdhomerunSessionsMu.Unlock()
+
+	if hdhomerunSessions >= tuners {
+		return false
+	}
+
+	hdhomerunSessions++
+	return true
+}
+
+func hdhomerunSessionRelease() {
+	hdhomerunSessionsMu.Lock()
+	defer hdhomerunSessionsMu.Unlock()
+
+	hdhomerunSessions--
+}
+
+// guide numbers stable across restarts and lineup changes, numeric tvg-id
+// is used if available, otherwise number is derived from stream ID
+func (a *ApiManagerCtx) hdhomerunGuideNumbers(ids []string) map[string]string {
+	numbers := map[string]string{}
+	used := map[string]bool{}
+
+	for _, id := range ids {
+		tvgId := a.config.StreamMeta(id).TvgId
+		if hdhomerunGuideNumberRegex.MatchString(tvgId) && !used[tvgId] {
+			numbers[id] = tvgId
+			used[tvgId] = true
+		}
+	}
+
+	for _, id := range ids {
+		if _, ok := numbers[id]; ok {
+			continue
+		}
+
+		hash := fnv.New32a()
+		_, _ = hash.Write([]byte(id))
+		n := int(hash.Sum32() % hdhomerunGuideNumberCount)
+
+		// collisions are resolved by the following free number
+		for used[strconv.Itoa(hdhomerunGuideNumberBase+n)] {
+			n = (n + 1) % hdhomerunGuideNumberCount
+		}
+
+		number := strconv.Itoa(hdhomerunGuideNumberBase + n)
+		numbers[id] = number
+		used[number] = true
+	}
+
+	return numbers
+}
+
+// UPnP device description
+func (a *ApiManagerCtx) hdhomerunDeviceXml(baseUrl string) []byte {
+	conf := a.config.HDHomeRun
+
+	var device hdhomerunDevice
+	device.SpecVersion.Major = 1
+	device.URLBase = baseUrl
+	device.Device.DeviceType = hdhomerunDeviceType
+	device.Device.FriendlyName = conf.FriendlyName
+	device.Device.Manufacturer = "Silicondust"
+	device.Device.ModelName = "HDTC-2US"
+	device.Device.ModelNumber = "HDTC-2US"
+	device.Device.SerialNumber = conf.DeviceID
+	device.Device.UDN = "uuid:" + conf.DeviceID
+
+	data, _ := xml.MarshalIndent(device, "", "  ")
+	return append([]byte(xml.Header), data...)
+}
+
+// answer SSDP searches, so that DVR clients can find device on local network
+func (a *ApiManagerCtx) hdhomerunSSDP(shutdown chan struct{}) {
+	logger := log.With().Str("module", "hdhomerun").Logger()
+
+	addr, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		logger.Err(err).Msg("unable to resolve ssdp address")
+		return
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, addr)
+	if err != nil {
+		logger.Err(err).Msg("unable to listen for ssdp")
+		return
+	}
+
+	go func() {
+		<-shutdown
+		conn.Close()
+	}()
+
+	logger.Info().Msg("ssdp discovery is active")
+
+	buf := make([]byte, 2048)
+	for {
+		n, remote, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-shutdown:
+				return
+			default:
+			}
+
+			logger.Warn().Err(err).Msg("unable to read ssdp request")
+			continue
+		}
+
+		st, ok := ssdpSearchTarget(buf[:n])
+		if !ok {
+			continue
+		}
+
+		uuid := "uuid:" + a.config.HDHomeRun.DeviceID
+		usn := uuid
+		switch st {
+		case "ssdp:all", "upnp:rootdevice", hdhomerunDeviceType:
+			if st == "ssdp:all" {
+				st = "upnp:rootdevice"
+			}
+			usn = uuid + "::" + st
+		case uuid:
+		default:
+			continue
+		}
+
+		location, err := a.hdhomerunLocation(remote)
+		if err != nil {
+			logger.Warn().Err(err).Msg("unable to get ssdp location")
+			continue
+		}
+
+		response := "HTTP/1.1 200 OK\r\n" +
+			"CACHE-CONTROL: max-age=1800\r\n" +
+			"EXT:\r\n" +
+			"LOCATION: " + location + "\r\n" +
+			"SERVER: go-transcode UPnP/1.0 HDHomeRun/1.0\r\n" +
+			"ST: " + st + "\r\n" +
+			"USN: " + usn + "\r\n" +
+			"\r\n"
+
+		// responses are sent as unicast to the searching client
+		if _, err := conn.WriteToUDP([]byte(response), remote); err != nil {
+			logger.Debug().Err(err).Str("remote", remote.String()).Msg("unable to send ssdp response")
+		}
+	}
+}
+
+// device description url, reachable from remote address
+func (a *ApiManagerCtx) hdhomerunLocation(remote *net.UDPAddr) (string, error) {
+	if baseUrl := a.config.HDHomeRun.BaseUrl; baseUrl != "" {
+		return strings.TrimSuffix(baseUrl, "/") + "/device.xml", nil
+	}
+
+	bindHost, port, err := net.SplitHostPort(a.config.Bind)
+	if err != nil {
+		return "", fmt.Errorf("bind is not host:port, set base-url: %w", err)
+	}
+
+	// local address used for routing to remote, no packets are sent
+	conn, err := net.DialUDP("udp4", nil, remote)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	localIP := conn.LocalAddr().(*net.UDPAddr).IP
+
+	// we are reachable on that address only if we listen on all interfaces or on that one
+	if bindIP := net.ParseIP(bindHost); bindHost != "" && !bindIP.IsUnspecified() && !bindIP.Equal(localIP) {
+		return "", fmt.Errorf("bind host %s is not reachable from %s, set base-url", bindHost, remote.IP)
+	}
+
+	host := localIP.String()
+
+	scheme := "http"
+	if a.config.Cert != "" && a.config.Key != "" {
+		scheme = "https"
+	}
+
+	return scheme + "://" + net.JoinHostPort(host, port) + "/device.xml", nil
+}
+
+// returns ST header of M-SEARCH request
+func ssdpSearchTarget(data []byte) (string, bool) {
+	reader := bufio.NewReader(bytes.NewReader(data))
+
+	line, err := reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "M-SEARCH ") {
+		return "", false
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if i := strings.Index(line, ":"); i > 0 && strings.EqualFold(strings.TrimSpace(line[:i]), "ST") {
+			return strings.TrimSpace(line[i+1:]), true
+		}
+		if err != nil {
+			return "", false
+		}
+	}
+}
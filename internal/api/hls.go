@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"os/exec"
+	"sync"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/rs/zerolog/log"
@@ -13,81 +14,129 @@ import (
 )
 
 var hlsManagers map[string]hls.Manager = make(map[string]hls.Manager)
+var hlsManagersMu sync.RWMutex
 
 //go:embed play.html
 var playHTML string
 
 func (a *ApiManagerCtx) HLS(r chi.Router) {
 	r.Get("/{profile}/{input}/index.m3u8", func(w http.ResponseWriter, r *http.Request) {
-		logger := log.With().Str("module", "hls").Logger()
-
-		profile := chi.URLParam(r, "profile")
-		input := chi.URLParam(r, "input")
-
-		if !resourceRegex.MatchString(profile) || !resourceRegex.MatchString(input) {
-			http.Error(w, "400 invalid parameters", http.StatusBadRequest)
-			return
-		}
-
-		// check if stream exists
-		_, ok := a.config.Streams[input]
-		if !ok {
-			http.Error(w, "404 stream not found", http.StatusNotFound)
-			return
+		if manager, ok := a.hlsManager(w, r); ok {
+			manager.ServePlaylist(w, r)
 		}
+	})
 
-		// check if profile exists
-		profilePath, err := a.ProfilePath("hls", profile)
-		if err != nil {
-			logger.Warn().Err(err).Msg("profile path could not be found")
-			http.Error(w, "404 profile not found", http.StatusNotFound)
-			return
+	r.Get("/{profile}/{input}/master.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		if manager, ok := a.hlsManager(w, r); ok {
+			manager.ServeMaster(w, r)
 		}
+	})
 
-		ID := fmt.Sprintf("%s/%s", profile, input)
-
-		manager, ok := hlsManagers[ID]
-		if !ok {
-			// create new manager
-			manager = hls.New(func() *exec.Cmd {
-				// get transcode cmd
-				cmd, err := a.transcodeStart(profilePath, input)
-				if err != nil {
-					logger.Error().Err(err).Msg("transcode could not be started")
-				}
+	r.Get("/{profile}/{input}/{file}.ts", a.hlsMedia)
 
-				return cmd
-			})
+	// subtitles playlist and WebVTT segments
+	r.Get("/{profile}/{input}/{file}.m3u8", a.hlsMedia)
+	r.Get("/{profile}/{input}/{file}.vtt", a.hlsMedia)
 
-			hlsManagers[ID] = manager
-		}
+	// fMP4 segments (e.g. for opus audio)
+	r.Get("/{profile}/{input}/{file}.m4s", a.hlsMedia)
+	r.Get("/{profile}/{input}/{file}.mp4", a.hlsMedia)
 
-		manager.ServePlaylist(w, r)
+	r.Get("/{profile}/{input}/play.html", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(playHTML))
 	})
+}
 
-	r.Get("/{profile}/{input}/{file}.ts", func(w http.ResponseWriter, r *http.Request) {
-		profile := chi.URLParam(r, "profile")
-		input := chi.URLParam(r, "input")
-		file := chi.URLParam(r, "file")
+// get existing or create new hls manager for requested profile and input
+func (a *ApiManagerCtx) hlsManager(w http.ResponseWriter, r *http.Request) (hls.Manager, bool) {
+	logger := log.With().Str("module", "hls").Logger()
+
+	profile := chi.URLParam(r, "profile")
+	input := chi.URLParam(r, "input")
+
+	if !resourceRegex.MatchString(profile) || !streamIdRegex.MatchString(input) {
+		http.Error(w, "400 invalid parameters", http.StatusBadRequest)
+		return nil, false
+	}
+
+	// check if stream exists
+	_, ok := a.config.Stream(input)
+	if !ok {
+		http.Error(w, "404 stream not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	// check if profile exists
+	profilePath, err := a.ProfilePath("hls", profile)
+	if err != nil {
+		logger.Warn().Err(err).Msg("profile path could not be found")
+		http.Error(w, "404 profile not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	ID := fmt.Sprintf("%s/%s", profile, input)
+
+	// enigma2 receiver has limited number of tuners, released when transcode stops
+	if err := a.tunerAcquire(r.Context(), "hls/"+ID, input); err != nil {
+		logger.Warn().Err(err).Msg("no tuner available")
+		tunerError(w, err)
+		return nil, false
+	}
+
+	hlsManagersMu.Lock()
+	defer hlsManagersMu.Unlock()
+
+	manager, ok := hlsManagers[ID]
+	if !ok {
+		// create new manager
+		manager = hls.New(func() *exec.Cmd {
+			// tuner is released whenever transcode stops, it must be acquired again on restart
+			if err := a.tunerAcquireNow("hls/"+ID, input); err != nil {
+				logger.Warn().Err(err).Msg("no tuner available")
+				return nil
+			}
+
+			// get transcode cmd
+			cmd, err := a.transcodeStart(profilePath, input)
+			if err != nil {
+				logger.Error().Err(err).Msg("transcode could not be started")
+				a.tunerRelease("hls/" + ID)
+			}
+
+			return cmd
+		})
+
+		manager.OnStop(func(err error) {
+			a.tunerRelease("hls/" + ID)
+		})
+
+		hlsManagers[ID] = manager
+	}
+
+	return manager, true
+}
 
-		if !resourceRegex.MatchString(profile) || !resourceRegex.MatchString(input) || !resourceRegex.MatchString(file) {
-			http.Error(w, "400 invalid parameters", http.StatusBadRequest)
-			return
-		}
+func (a *ApiManagerCtx) hlsMedia(w http.ResponseWriter, r *http.Request) {
+	profile := chi.URLParam(r, "profile")
+	input := chi.URLParam(r, "input")
+	file := chi.URLParam(r, "file")
 
-		ID := fmt.Sprintf("%s/%s", profile, input)
+	if !resourceRegex.MatchString(profile) || !streamIdRegex.MatchString(input) || !resourceRegex.MatchString(file) {
+		http.Error(w, "400 invalid parameters", http.StatusBadRequest)
+		return
+	}
 
-		manager, ok := hlsManagers[ID]
-		if !ok {
-			http.Error(w, "404 transcode not found", http.StatusNotFound)
-			return
-		}
+	ID := fmt.Sprintf("%s/%s", profile, input)
 
-		manager.ServeMedia(w, r)
-	})
+	hlsManagersMu.RLock()
+	manager, ok := hlsManagers[ID]
+	hlsManagersMu.RUnlock()
 
-	r.Get("/{profile}/{input}/play.html", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html")
-		_, _ = w.Write([]byte(playHTML))
-	})
+	if !ok {
+		http.Error(w, "404 transcode not found", http.StatusNotFound)
+		return
+	}
+
+	manager.ServeMedia(w, r)
 }
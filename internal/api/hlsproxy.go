@@ -1,41 +1,149 @@
 package api
 
 import (
+	"crypto/subtle"
+	"encoding/hex"
 	"net/http"
+	"net/url"
+	"path"
 	"strings"
+	"sync"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
 
 	"github.com/m1k1o/go-transcode/hlsproxy"
+	"github.com/m1k1o/go-transcode/internal/config"
 )
 
 const hlsProxyPerfix = "/hlsproxy/"
 
 var hlsProxyManagers map[string]hlsproxy.Manager = make(map[string]hlsproxy.Manager)
+var hlsProxyManagersMu sync.Mutex
+
+// cache shared by all hls and dash proxy managers
+var hlsProxyCache hlsproxy.Cache
+var hlsProxyCacheOnce sync.Once
 
 func (a *ApiManagerCtx) HLSProxy(r chi.Router) {
+	a.hlsProxyCacheInit()
+
+	// our own transcoded variants
+	r.Get(hlsProxyPerfix+"{sourceId}/"+hlsProxyTranscodePath+"{profile}/*", a.hlsProxyTranscode)
+	r.Get(hlsProxyPerfix+"{sourceId}/"+hlsProxySourcePath+"*", a.hlsProxySource)
+
 	r.Get(hlsProxyPerfix+"{sourceId}/*", func(w http.ResponseWriter, r *http.Request) {
 		ID := chi.URLParam(r, "sourceId")
 
 		// check if stream exists
-		baseUrl, ok := a.config.HlsProxy[ID]
+		conf, ok := a.config.HlsProxy[ID]
 		if !ok {
 			http.Error(w, "404 hls proxy source not found", http.StatusNotFound)
 			return
 		}
 
+		hlsProxyManagersMu.Lock()
 		manager, ok := hlsProxyManagers[ID]
 		if !ok {
+			config := a.hlsProxyConfig(hlsProxyPerfix+ID+"/", conf)
+			config.Variants = a.hlsProxyVariants(ID, conf)
+
 			// create new manager
-			manager = hlsproxy.New(baseUrl, hlsProxyPerfix+ID+"/")
+			manager = hlsproxy.New(config)
 			hlsProxyManagers[ID] = manager
 		}
+		hlsProxyManagersMu.Unlock()
 
 		// if this is playlist request
-		if strings.HasSuffix(r.URL.String(), ".m3u8") {
+		if path.Ext(r.URL.Path) == ".m3u8" {
 			manager.ServePlaylist(w, r)
 		} else {
 			manager.ServeMedia(w, r)
 		}
 	})
 }
+
+// create cache shared by all hls and dash proxy managers
+func (a *ApiManagerCtx) hlsProxyCacheInit() {
+	hlsProxyCacheOnce.Do(func() {
+		conf := a.config.HlsProxyCache
+		if conf.Type == "disk" {
+			var err error
+			hlsProxyCache, err = hlsproxy.NewDiskCache(conf.Dir, conf.MaxSize*1024*1024)
+			if err != nil {
+				log.Panic().Err(err).Msg("unable to create hls proxy disk cache")
+			}
+		} else {
+			hlsProxyCache = hlsproxy.NewMemoryCache(conf.MaxSize * 1024 * 1024)
+		}
+	})
+}
+
+func (a *ApiManagerCtx) hlsProxyConfig(prefix string, conf config.HlsProxy) hlsproxy.Config {
+	// validated when loading config
+	query, _ := url.ParseQuery(conf.Query)
+	key, _ := hex.DecodeString(conf.Keys.Key)
+
+	return hlsproxy.Config{
+		BaseUrl: conf.Url,
+		Mirrors: conf.Mirrors,
+		Prefix:  prefix,
+		Cache:   hlsProxyCache,
+
+		RangeCacheLimit: int64(a.config.HlsProxyCache.RangeLimit) * 1024 * 1024,
+
+		Headers:  conf.Headers,
+		Query:    query,
+		Username: conf.Auth.Username,
+		Password: conf.Auth.Password,
+		Token:    conf.Auth.Token,
+
+		Timeout:            conf.Timeout,
+		Retries:            conf.Retries,
+		RetryDelay:         conf.RetryDelay,
+		InsecureSkipVerify: conf.Insecure,
+		ProxyUrl:           conf.ProxyUrl,
+
+		KeyExpiration: conf.Keys.Expiration,
+		Decrypt:       conf.Keys.Decrypt,
+		Reencrypt:     conf.Keys.Reencrypt,
+		ReencryptKey:  key,
+		KeyAuth:       tokenAuth(conf.Keys.Tokens),
+		KeyAuthQuery:  "token",
+
+		Rules: hlsproxy.Rules{
+			MaxBandwidth: conf.Rewrite.MaxBandwidth,
+			MaxHeight:    conf.Rewrite.MaxResolution,
+			Order:        conf.Rewrite.Order,
+			StripTags:    conf.Rewrite.StripTags,
+			DefaultAudio: conf.Rewrite.DefaultAudio,
+			Inject:       conf.Rewrite.Inject,
+		},
+
+		Prefetch:            conf.Prefetch.Segments,
+		PrefetchConcurrency: conf.Prefetch.Concurrency,
+		PrefetchBandwidth:   conf.Prefetch.Bandwidth * 1024,
+		PrefetchIdle:        conf.Prefetch.Idle,
+	}
+}
+
+// authorize requests with one of tokens, as bearer or query parameter
+func tokenAuth(tokens []string) func(r *http.Request) bool {
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	return func(r *http.Request) bool {
+		token := r.URL.Query().Get("token")
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			token = strings.TrimPrefix(auth, "Bearer ")
+		}
+
+		for _, t := range tokens {
+			if token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+				return true
+			}
+		}
+		return false
+	}
+}
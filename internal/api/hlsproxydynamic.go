@@ -0,0 +1,302 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+
+	"github.com/m1k1o/go-transcode/hlsproxy"
+)
+
+// upstream is encoded in url as /hlsproxy/url/<base64url>/<signature>/...
+const hlsProxyDynamicPath = "url"
+
+// sources used recently are not removed, even if no request is in progress
+const hlsProxyDynamicIdle = time.Minute
+
+var errHlsProxyDynamicBusy = errors.New("all sources are in use")
+
+type hlsProxyDynamicSource struct {
+	manager  hlsproxy.Manager
+	active   int // requests in progress
+	lastUsed time.Time
+}
+
+var hlsProxyDynamicSources = map[string]*hlsProxyDynamicSource{}
+var hlsProxyDynamicMu sync.Mutex
+
+func (a *ApiManagerCtx) HLSProxyDynamic(r chi.Router) {
+	a.hlsProxyCacheInit()
+
+	// sign upstream url and redirect to its proxied version
+	r.Get(hlsProxyPerfix+hlsProxyDynamicPath, a.hlsProxyDynamicSign)
+
+	r.Get(hlsProxyPerfix+hlsProxyDynamicPath+"/{encoded}/*", func(w http.ResponseWriter, r *http.Request) {
+		encoded := chi.URLParam(r, "encoded")
+		prefix := hlsProxyPerfix + hlsProxyDynamicPath + "/" + encoded + "/"
+
+		data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+		if err != nil {
+			http.Error(w, "400 invalid upstream url", http.StatusBadRequest)
+			return
+		}
+		baseUrl := string(data)
+
+		// base is directory, so that request path can not change its host
+		if u, err := url.Parse(baseUrl); err != nil || !strings.HasSuffix(u.Path, "/") || u.RawQuery != "" || u.Fragment != "" {
+			http.Error(w, "400 invalid upstream url", http.StatusBadRequest)
+			return
+		}
+
+		signature := strings.SplitN(chi.URLParam(r, "*"), "/", 2)[0]
+		if !hmac.Equal([]byte(signature), []byte(a.hlsProxyDynamicSignature(baseUrl))) {
+			http.Error(w, "403 invalid signature", http.StatusForbidden)
+			return
+		}
+		prefix += signature + "/"
+
+		manager, release, err := a.hlsProxyDynamicManager(r.Context(), prefix, baseUrl)
+		if errors.Is(err, errHlsProxyDynamicBusy) {
+			log.Warn().Str("url", baseUrl).Msg("hls proxy dynamic has too many sources in use")
+			http.Error(w, "503 too many sources", http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			log.Warn().Err(err).Str("url", baseUrl).Msg("hls proxy dynamic upstream rejected")
+			http.Error(w, "403 upstream not allowed", http.StatusForbidden)
+			return
+		}
+		defer release()
+
+		switch path.Ext(r.URL.Path) {
+		case ".m3u8":
+			manager.ServePlaylist(w, r)
+		case ".mpd":
+			manager.ServeManifest(w, r)
+		default:
+			manager.ServeMedia(w, r)
+		}
+	})
+}
+
+func (a *ApiManagerCtx) hlsProxyDynamicSign(w http.ResponseWriter, r *http.Request) {
+	conf := a.config.HlsProxyDynamic
+
+	// tokens are required by config
+	if auth := tokenAuth(conf.Tokens); auth == nil || !auth(r) {
+		http.Error(w, "403 forbidden", http.StatusForbidden)
+		return
+	}
+
+	u, err := url.Parse(r.URL.Query().Get("url"))
+	if err != nil || u.Host == "" {
+		http.Error(w, "400 invalid url", http.StatusBadRequest)
+		return
+	}
+
+	if err := a.hlsProxyDynamicAllowed(r.Context(), u); err != nil {
+		log.Warn().Err(err).Str("url", u.String()).Msg("hls proxy dynamic upstream rejected")
+		http.Error(w, "403 upstream not allowed", http.StatusForbidden)
+		return
+	}
+
+	// upstream base is directory of requested resource
+	dir, file := path.Split(u.EscapedPath())
+	if dir == "" {
+		dir = "/"
+	}
+	base := *u
+	base.RawPath, base.Path = "", ""
+	base.RawQuery, base.Fragment = "", ""
+	baseUrl := base.String() + dir
+	if u.RawQuery != "" {
+		file += "?" + u.RawQuery
+	}
+
+	location := hlsProxyPerfix + hlsProxyDynamicPath + "/" + base64.RawURLEncoding.EncodeToString([]byte(baseUrl)) + "/" + a.hlsProxyDynamicSignature(baseUrl) + "/"
+
+	http.Redirect(w, r, location+file, http.StatusFound)
+}
+
+func (a *ApiManagerCtx) hlsProxyDynamicSignature(baseUrl string) string {
+	mac := hmac.New(sha256.New, []byte(a.config.HlsProxyDynamic.Secret))
+	mac.Write([]byte(baseUrl))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// get existing or create new manager, least recently used idle one is removed when limit is reached,
+// returned release func must be called when request is served
+func (a *ApiManagerCtx) hlsProxyDynamicManager(ctx context.Context, prefix, baseUrl string) (hlsproxy.Manager, func(), error) {
+	hlsProxyDynamicMu.Lock()
+	source, ok := hlsProxyDynamicSources[prefix]
+	if ok {
+		source.acquire()
+		hlsProxyDynamicMu.Unlock()
+		return source.manager, source.release, nil
+	}
+	hlsProxyDynamicMu.Unlock()
+
+	u, err := url.Parse(baseUrl)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := a.hlsProxyDynamicAllowed(ctx, u); err != nil {
+		return nil, nil, err
+	}
+
+	hlsProxyDynamicMu.Lock()
+	defer hlsProxyDynamicMu.Unlock()
+
+	// created meanwhile
+	if source, ok := hlsProxyDynamicSources[prefix]; ok {
+		source.acquire()
+		return source.manager, source.release, nil
+	}
+
+	if len(hlsProxyDynamicSources) >= a.config.HlsProxyDynamic.MaxSources {
+		// sources still being played are not removed
+		var oldestKey string
+		var oldest *hlsProxyDynamicSource
+		for key, source := range hlsProxyDynamicSources {
+			if source.active > 0 || time.Since(source.lastUsed) < hlsProxyDynamicIdle {
+				continue
+			}
+			if oldest == nil || source.lastUsed.Before(oldest.lastUsed) {
+				oldestKey, oldest = key, source
+			}
+		}
+
+		if oldest == nil {
+			return nil, nil, errHlsProxyDynamicBusy
+		}
+
+		oldest.manager.Shutdown()
+		delete(hlsProxyDynamicSources, oldestKey)
+	}
+
+	conf := a.config.HlsProxyDynamic
+	manager := hlsproxy.New(hlsproxy.Config{
+		BaseUrl: baseUrl,
+		Prefix:  prefix,
+		Cache:   hlsProxyCache,
+
+		RangeCacheLimit: int64(a.config.HlsProxyCache.RangeLimit) * 1024 * 1024,
+
+		Timeout:            conf.Timeout,
+		Retries:            conf.Retries,
+		InsecureSkipVerify: conf.Insecure,
+
+		// allow-list is enforced on redirects and on every connection
+		RedirectCheck: func(u *url.URL) error {
+			return a.hlsProxyDynamicAllowed(context.Background(), u)
+		},
+		DialCheck: a.hlsProxyDynamicDialAllowed,
+	})
+
+	source = &hlsProxyDynamicSource{
+		manager: manager,
+	}
+	source.acquire()
+	hlsProxyDynamicSources[prefix] = source
+
+	return manager, source.release, nil
+}
+
+// hlsProxyDynamicMu must be held
+func (s *hlsProxyDynamicSource) acquire() {
+	s.active++
+	s.lastUsed = time.Now()
+}
+
+func (s *hlsProxyDynamicSource) release() {
+	hlsProxyDynamicMu.Lock()
+	defer hlsProxyDynamicMu.Unlock()
+
+	s.active--
+	s.lastUsed = time.Now()
+}
+
+// upstream host must match allowed host, or all its addresses must be in allowed networks
+func (a *ApiManagerCtx) hlsProxyDynamicAllowed(ctx context.Context, u *url.URL) error {
+	conf := a.config.HlsProxyDynamic
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+
+	if u.User != nil {
+		return fmt.Errorf("credentials in url are not allowed")
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if hlsProxyDynamicHostAllowed(conf.AllowHosts, host) {
+		return nil
+	}
+
+	if len(conf.AllowCidrs) == 0 {
+		return fmt.Errorf("host %s is not allowed", host)
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+
+	for _, addr := range addrs {
+		if !hlsProxyDynamicCidrAllowed(conf.AllowCidrs, addr.IP) {
+			return fmt.Errorf("address %s of host %s is not allowed", addr.IP, host)
+		}
+	}
+
+	return nil
+}
+
+// checked for every upstream connection, as host can resolve to different address than when checked
+func (a *ApiManagerCtx) hlsProxyDynamicDialAllowed(host string, ip net.IP) error {
+	conf := a.config.HlsProxyDynamic
+
+	if hlsProxyDynamicHostAllowed(conf.AllowHosts, strings.ToLower(host)) {
+		return nil
+	}
+
+	if !hlsProxyDynamicCidrAllowed(conf.AllowCidrs, ip) {
+		return fmt.Errorf("address %s of host %s is not allowed", ip, host)
+	}
+
+	return nil
+}
+
+func hlsProxyDynamicHostAllowed(hosts []string, host string) bool {
+	for _, allowed := range hosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed || (strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+func hlsProxyDynamicCidrAllowed(cidrs []string, ip net.IP) bool {
+	for _, cidr := range cidrs {
+		// validated when loading config
+		_, network, _ := net.ParseCIDR(cidr)
+		if network != nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,234 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+
+	"github.com/m1k1o/go-transcode/hls"
+	"github.com/m1k1o/go-transcode/hlsproxy"
+	"github.com/m1k1o/go-transcode/internal/config"
+)
+
+// path under hls proxy, where our own transcoded variants are served,
+// it shadows the same upstream path, as documented in config
+const hlsProxyTranscodePath = "_transcode/"
+
+// path under hls proxy, where upstream is served without our own variants, used as transcode input,
+// it shadows the same upstream path as well
+const hlsProxySourcePath = "_source/"
+
+// used when profile does not export its bandwidth
+const hlsProxyTranscodeBandwidth = 3000000
+
+var hlsProxyTranscodeManagers map[string]hls.Manager = make(map[string]hls.Manager)
+var hlsProxyTranscodeManagersMu sync.Mutex
+
+var hlsProxyTranscodeFileRegex = regexp.MustCompile(`^[0-9A-Za-z_-]+\.(m3u8|ts|m4s|mp4|vtt)$`)
+
+// export VW="1280"
+var profileExportRegex = regexp.MustCompile(`^export\s+([A-Z]+)="?([^"]*)"?$`)
+
+func (a *ApiManagerCtx) hlsProxyTranscode(w http.ResponseWriter, r *http.Request) {
+	logger := log.With().Str("module", "hlsproxy").Logger()
+
+	ID := chi.URLParam(r, "sourceId")
+	profile := chi.URLParam(r, "profile")
+	file := chi.URLParam(r, "*")
+
+	if !resourceRegex.MatchString(profile) || !hlsProxyTranscodeFileRegex.MatchString(file) {
+		http.Error(w, "400 invalid parameters", http.StatusBadRequest)
+		return
+	}
+
+	conf, ok := a.config.HlsProxy[ID]
+	if !ok || !hlsProxyTranscodeAllowed(conf, profile) {
+		http.Error(w, "404 transcode not found", http.StatusNotFound)
+		return
+	}
+
+	managerID := fmt.Sprintf("%s/%s", ID, profile)
+
+	hlsProxyTranscodeManagersMu.Lock()
+	manager, ok := hlsProxyTranscodeManagers[managerID]
+	if !ok {
+		profilePath, err := a.ProfilePath("hls", profile)
+		if err != nil {
+			hlsProxyTranscodeManagersMu.Unlock()
+			logger.Warn().Err(err).Msg("profile path could not be found")
+			http.Error(w, "404 profile not found", http.StatusNotFound)
+			return
+		}
+
+		// transcode from our own proxy, so that upstream settings and cache are used,
+		// but without our own variants, that would be transcoded again
+		input := a.localUrl() + hlsProxyPerfix + ID + "/" + hlsProxySourcePath + strings.TrimPrefix(conf.Transcode.Input, "/")
+
+		// our own key is requested by ffmpeg as well
+		if conf.Keys.Reencrypt && len(conf.Keys.Tokens) > 0 {
+			input += "?" + url.Values{"token": {conf.Keys.Tokens[0]}}.Encode()
+		}
+
+		manager = hls.New(func() *exec.Cmd {
+			log.Info().Str("profilePath", profilePath).Str("url", input).Msg("command startred")
+			return exec.Command(profilePath, input)
+		})
+
+		hlsProxyTranscodeManagers[managerID] = manager
+	}
+	hlsProxyTranscodeManagersMu.Unlock()
+
+	if file == "index.m3u8" {
+		manager.ServePlaylist(w, r)
+	} else {
+		manager.ServeMedia(w, r)
+	}
+}
+
+// upstream as it is, without our own variants
+func (a *ApiManagerCtx) hlsProxySource(w http.ResponseWriter, r *http.Request) {
+	ID := chi.URLParam(r, "sourceId")
+
+	conf, ok := a.config.HlsProxy[ID]
+	if !ok || len(conf.Transcode.Profiles) == 0 {
+		http.Error(w, "404 hls proxy source not found", http.StatusNotFound)
+		return
+	}
+
+	managerID := ID + "/" + hlsProxySourcePath
+
+	hlsProxyManagersMu.Lock()
+	manager, ok := hlsProxyManagers[managerID]
+	if !ok {
+		manager = hlsproxy.New(a.hlsProxyConfig(hlsProxyPerfix+managerID, conf))
+		hlsProxyManagers[managerID] = manager
+	}
+	hlsProxyManagersMu.Unlock()
+
+	if path.Ext(r.URL.Path) == ".m3u8" {
+		manager.ServePlaylist(w, r)
+	} else {
+		manager.ServeMedia(w, r)
+	}
+}
+
+func hlsProxyTranscodeAllowed(conf config.HlsProxy, profile string) bool {
+	for _, p := range conf.Transcode.Profiles {
+		if p == profile {
+			return true
+		}
+	}
+	return false
+}
+
+// variants advertised in proxied master playlist
+func (a *ApiManagerCtx) hlsProxyVariants(ID string, conf config.HlsProxy) []hlsproxy.Variant {
+	variants := []hlsproxy.Variant{}
+
+	for _, profile := range conf.Transcode.Profiles {
+		profilePath, err := a.ProfilePath("hls", profile)
+		if err != nil {
+			log.Warn().Err(err).Str("profile", profile).Msg("hls proxy transcode profile could not be found")
+			continue
+		}
+
+		variant := hlsproxy.Variant{
+			Uri:       hlsProxyPerfix + ID + "/" + hlsProxyTranscodePath + profile + "/index.m3u8",
+			Bandwidth: hlsProxyTranscodeBandwidth,
+		}
+
+		// read advertised parameters from profile exports
+		exports := profileExports(profilePath)
+		if exports["VW"] != "" && exports["VH"] != "" {
+			variant.Resolution = exports["VW"] + "x" + exports["VH"]
+		}
+		if bandwidth := parseBitrate(exports["VBANDWIDTH"]) + parseBitrate(exports["ABANDWIDTH"]); bandwidth > 0 {
+			variant.Bandwidth = bandwidth
+		}
+
+		variants = append(variants, variant)
+	}
+
+	return variants
+}
+
+func profileExports(profilePath string) map[string]string {
+	exports := map[string]string{}
+
+	file, err := os.Open(profilePath)
+	if err != nil {
+		return exports
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		match := profileExportRegex.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if match != nil {
+			exports[match[1]] = match[2]
+		}
+	}
+
+	return exports
+}
+
+// parse ffmpeg bitrate, e.g. 2800k
+func parseBitrate(bitrate string) int {
+	multiplier := 1
+	if strings.HasSuffix(bitrate, "k") {
+		multiplier = 1000
+		bitrate = strings.TrimSuffix(bitrate, "k")
+	} else if strings.HasSuffix(bitrate, "M") {
+		multiplier = 1000000
+		bitrate = strings.TrimSuffix(bitrate, "M")
+	}
+
+	value, err := strconv.Atoi(bitrate)
+	if err != nil {
+		return 0
+	}
+	return value * multiplier
+}
+
+// url under which is this server reachable locally, bind address is
+// host:port, :port or empty, as accepted by http server
+func (a *ApiManagerCtx) localUrl() string {
+	scheme := "http"
+	if a.config.Cert != "" {
+		scheme = "https"
+	}
+
+	host, port, err := net.SplitHostPort(a.config.Bind)
+	if err != nil {
+		// empty bind address listens on default port
+		host, port = "", scheme
+	}
+
+	// named port, e.g. :http
+	if number, err := net.LookupPort("tcp", port); err == nil {
+		port = strconv.Itoa(number)
+	}
+
+	// listening on all interfaces, use loopback of the same family
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		if ip != nil && ip.To4() == nil {
+			host = "::1"
+		} else {
+			host = "127.0.0.1"
+		}
+	}
+
+	return scheme + "://" + net.JoinHostPort(host, port)
+}
@@ -59,17 +59,7 @@ func (a *ApiManagerCtx) HlsVod(r chi.Router) {
 		} else
 		// serve master profile
 		if hlsResource == "index.m3u8" {
-			data, err := hlsvod.New(hlsvod.Config{
-				MediaPath:      vodMediaPath,
-				VideoKeyframes: a.config.Vod.VideoKeyframes,
-
-				Cache:    a.config.Vod.Cache,
-				CacheDir: a.config.Vod.CacheDir,
-
-				FFmpegBinary:  a.config.Vod.FFmpegBinary,
-				FFprobeBinary: a.config.Vod.FFprobeBinary,
-			}).Preload(r.Context())
-
+			data, err := a.vodProbe(r.Context(), vodMediaPath)
 			if err != nil {
 				logger.Warn().Err(err).Msg("unable to preload metadata")
 				http.Error(w, "500 unable to preload metadata", http.StatusInternalServerError)
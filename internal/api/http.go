@@ -1,16 +1,32 @@
 package api
 
 import (
+	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"os/exec"
+	"path"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
 	"github.com/m1k1o/go-transcode/internal/utils"
 )
 
+// container used when input has no extension
+const httpDefaultContainer = "ts"
+
+// supported containers and their content types
+var httpContainers = map[string]string{
+	"ts":   "video/mp2t",
+	"mp4":  "video/mp4",
+	"mkv":  "video/x-matroska",
+	"webm": "video/webm",
+}
+
 func (a *ApiManagerCtx) Http(r chi.Router) {
 	r.Get("/test", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "video/mp2t")
@@ -42,54 +58,7 @@ func (a *ApiManagerCtx) Http(r chi.Router) {
 	})
 
 	r.Get("/{profile}/{input}", func(w http.ResponseWriter, r *http.Request) {
-		logger := log.With().
-			Str("path", r.URL.Path).
-			Str("module", "ffmpeg").
-			Logger()
-
-		profile := chi.URLParam(r, "profile")
-		input := chi.URLParam(r, "input")
-
-		// check if stream exists
-		_, ok := a.config.Streams[input]
-		if !ok {
-			http.Error(w, "404 stream not found", http.StatusNotFound)
-			return
-		}
-
-		// check if profile exists
-		profilePath, err := a.ProfilePath("hls", profile)
-		if err != nil {
-			logger.Warn().Err(err).Msg("profile path could not be found")
-			http.Error(w, "404 profile not found", http.StatusNotFound)
-			return
-		}
-
-		cmd, err := a.transcodeStart(profilePath, input)
-		if err != nil {
-			logger.Warn().Err(err).Msg("transcode could not be started")
-			http.Error(w, "500 not available", http.StatusInternalServerError)
-			return
-		}
-
-		logger.Info().Msg("command started")
-		w.Header().Set("Content-Type", "video/mp2t")
-
-		read, write := io.Pipe()
-		cmd.Stdout = write
-		cmd.Stderr = utils.LogWriter(logger)
-
-		defer func() {
-			logger.Info().Msg("command stopped")
-
-			read.Close()
-			write.Close()
-		}()
-
-		go func() {
-			_ = cmd.Run()
-		}()
-		_, _ = io.Copy(w, read)
+		a.httpStream(w, r, chi.URLParam(r, "profile"))
 	})
 
 	// buffered http streaming (alternative to prervious type)
@@ -99,33 +68,11 @@ func (a *ApiManagerCtx) Http(r chi.Router) {
 			Str("module", "ffmpeg").
 			Logger()
 
-		profile := chi.URLParam(r, "profile")
-		input := chi.URLParam(r, "input")
-
-		// check if stream exists
-		_, ok := a.config.Streams[input]
+		cmd, release, ok := a.httpTranscodeStart(w, r, chi.URLParam(r, "profile"), logger)
 		if !ok {
-			http.Error(w, "404 stream not found", http.StatusNotFound)
 			return
 		}
-
-		// check if profile exists
-		profilePath, err := a.ProfilePath("hls", profile)
-		if err != nil {
-			logger.Warn().Err(err).Msg("profile path could not be found")
-			http.Error(w, "404 profile not found", http.StatusNotFound)
-			return
-		}
-
-		cmd, err := a.transcodeStart(profilePath, input)
-		if err != nil {
-			logger.Warn().Err(err).Msg("transcode could not be started")
-			http.Error(w, "500 not available", http.StatusInternalServerError)
-			return
-		}
-
-		logger.Info().Msg("command started")
-		w.Header().Set("Content-Type", "video/mp2t")
+		defer release()
 
 		read, write := io.Pipe()
 		cmd.Stdout = write
@@ -137,3 +84,93 @@ func (a *ApiManagerCtx) Http(r chi.Router) {
 		logger.Info().Msg("command stopped")
 	})
 }
+
+// transcode stream to response by profile, until client disconnects
+func (a *ApiManagerCtx) httpStream(w http.ResponseWriter, r *http.Request, profile string) {
+	logger := log.With().
+		Str("path", r.URL.Path).
+		Str("module", "ffmpeg").
+		Logger()
+
+	cmd, release, ok := a.httpTranscodeStart(w, r, profile, logger)
+	if !ok {
+		return
+	}
+	defer release()
+
+	read, write := io.Pipe()
+	cmd.Stdout = write
+	cmd.Stderr = utils.LogWriter(logger)
+
+	defer func() {
+		logger.Info().Msg("command stopped")
+
+		read.Close()
+		write.Close()
+	}()
+
+	go func() {
+		_ = cmd.Run()
+	}()
+	_, _ = io.Copy(w, read)
+}
+
+// input can have container extension, e.g. /{profile}/{input}.mp4
+// returned release func must be called when transcode stops
+func (a *ApiManagerCtx) httpTranscodeStart(w http.ResponseWriter, r *http.Request, profile string, logger zerolog.Logger) (*exec.Cmd, func(), bool) {
+	input := chi.URLParam(r, "input")
+
+	// stream IDs can contain dots, e.g. sat.1, only known containers are extensions
+	container := httpDefaultContainer
+	if ext := path.Ext(input); ext != "" {
+		if _, ok := httpContainers[strings.TrimPrefix(ext, ".")]; ok {
+			container = strings.TrimPrefix(ext, ".")
+			input = strings.TrimSuffix(input, ext)
+		}
+	}
+
+	contentType := httpContainers[container]
+
+	// check if stream exists
+	_, ok := a.config.Stream(input)
+	if !ok {
+		http.Error(w, "404 stream not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	// check if profile exists
+	profilePath, err := a.ProfilePath("http", profile)
+	if err != nil {
+		logger.Warn().Err(err).Msg("profile path could not be found")
+		http.Error(w, "404 profile not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	// enigma2 receiver has limited number of tuners
+	sessionID := fmt.Sprintf("http/%p", r)
+	if err := a.tunerAcquire(r.Context(), sessionID, input); err != nil {
+		logger.Warn().Err(err).Msg("no tuner available")
+		tunerError(w, err)
+		return nil, nil, false
+	}
+
+	release := func() {
+		a.tunerRelease(sessionID)
+	}
+
+	cmd, err := a.transcodeStart(profilePath, input)
+	if err != nil {
+		release()
+		logger.Warn().Err(err).Msg("transcode could not be started")
+		http.Error(w, "500 not available", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+
+	// profile selects muxer by container
+	cmd.Env = append(os.Environ(), "CONTAINER="+container)
+
+	logger.Info().Str("container", container).Msg("command started")
+	w.Header().Set("Content-Type", contentType)
+
+	return cmd, release, true
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+
+	"github.com/m1k1o/go-transcode/internal/utils"
+)
+
+// how many audio bytes are sent between two ICY metadata blocks
+const icyMetaInt = 16000
+
+func (a *ApiManagerCtx) Icecast(r chi.Router) {
+	// icecast compatible audio streaming
+	r.Get("/{profile}/{input}/icecast", func(w http.ResponseWriter, r *http.Request) {
+		logger := log.With().
+			Str("path", r.URL.Path).
+			Str("module", "icecast").
+			Logger()
+
+		profile := chi.URLParam(r, "profile")
+		input := chi.URLParam(r, "input")
+
+		// check if stream exists
+		_, ok := a.config.Stream(input)
+		if !ok {
+			http.Error(w, "404 stream not found", http.StatusNotFound)
+			return
+		}
+
+		// check if profile exists
+		profilePath, err := a.ProfilePath("http", profile)
+		if err != nil {
+			logger.Warn().Err(err).Msg("profile path could not be found")
+			http.Error(w, "404 profile not found", http.StatusNotFound)
+			return
+		}
+
+		// enigma2 receiver has limited number of tuners
+		sessionID := fmt.Sprintf("icecast/%p", r)
+		if err := a.tunerAcquire(r.Context(), sessionID, input); err != nil {
+			logger.Warn().Err(err).Msg("no tuner available")
+			tunerError(w, err)
+			return
+		}
+		defer a.tunerRelease(sessionID)
+
+		cmd, err := a.transcodeStart(profilePath, input)
+		if err != nil {
+			logger.Warn().Err(err).Msg("transcode could not be started")
+			http.Error(w, "500 not available", http.StatusInternalServerError)
+			return
+		}
+
+		name := a.config.StreamMeta(input).Name
+		if name == "" {
+			name = input
+		}
+
+		read, write := io.Pipe()
+		cmd.Stdout = write
+		cmd.Stderr = utils.LogWriter(logger)
+
+		defer func() {
+			logger.Info().Msg("command stopped")
+
+			read.Close()
+			write.Close()
+		}()
+
+		logger.Info().Msg("command started")
+		go func() {
+			_ = cmd.Run()
+			write.Close()
+		}()
+
+		// read first chunk to detect content type
+		buf := make([]byte, utils.BUF_LEN)
+		n, err := io.ReadAtLeast(read, buf, 4)
+		if err != nil {
+			logger.Warn().Err(err).Msg("unable to read audio stream")
+			http.Error(w, "500 not available", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", audioContentType(buf[:n]))
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("icy-name", name)
+
+		var out io.Writer = w
+		if r.Header.Get("Icy-MetaData") == "1" {
+			w.Header().Set("icy-metaint", fmt.Sprintf("%d", icyMetaInt))
+			out = utils.IcyWriter(w, icyMetaInt, name)
+		}
+
+		w.WriteHeader(http.StatusOK)
+		if _, err := out.Write(buf[:n]); err != nil {
+			return
+		}
+
+		_, _ = io.Copy(out, read)
+	})
+}
+
+// detect audio content type from first bytes of the stream
+func audioContentType(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("OggS")):
+		return "audio/ogg"
+	case bytes.HasPrefix(data, []byte("ID3")):
+		return "audio/mpeg"
+	case len(data) >= 2 && data[0] == 0xFF && data[1]&0xF6 == 0xF0:
+		// ADTS sync word with layer 0
+		return "audio/aac"
+	case len(data) >= 2 && data[0] == 0xFF && data[1]&0xE0 == 0xE0:
+		return "audio/mpeg"
+	}
+
+	return "application/octet-stream"
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os/exec"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+
+	"github.com/m1k1o/go-transcode/hls"
+)
+
+const llhlsPrefix = "/llhls/"
+
+var llhlsManagers map[string]hls.Manager = make(map[string]hls.Manager)
+var llhlsManagersMu sync.RWMutex
+
+func (a *ApiManagerCtx) LLHLS(r chi.Router) {
+	r.Get(llhlsPrefix+"{profile}/{input}/index.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		logger := log.With().Str("module", "llhls").Logger()
+
+		profile := chi.URLParam(r, "profile")
+		input := chi.URLParam(r, "input")
+
+		if !resourceRegex.MatchString(profile) || !streamIdRegex.MatchString(input) {
+			http.Error(w, "400 invalid parameters", http.StatusBadRequest)
+			return
+		}
+
+		// check if stream exists
+		_, ok := a.config.Stream(input)
+		if !ok {
+			http.Error(w, "404 stream not found", http.StatusNotFound)
+			return
+		}
+
+		// check if profile exists
+		profilePath, err := a.ProfilePath("llhls", profile)
+		if err != nil {
+			logger.Warn().Err(err).Msg("profile path could not be found")
+			http.Error(w, "404 profile not found", http.StatusNotFound)
+			return
+		}
+
+		ID := fmt.Sprintf("%s/%s", profile, input)
+
+		// enigma2 receiver has limited number of tuners, released when transcode stops
+		if err := a.tunerAcquire(r.Context(), "llhls/"+ID, input); err != nil {
+			logger.Warn().Err(err).Msg("no tuner available")
+			tunerError(w, err)
+			return
+		}
+
+		llhlsManagersMu.Lock()
+		manager, ok := llhlsManagers[ID]
+		if !ok {
+			// create new manager
+			manager = hls.NewLowLatency(func() *exec.Cmd {
+				// tuner is released whenever transcode stops, it must be acquired again on restart
+				if err := a.tunerAcquireNow("llhls/"+ID, input); err != nil {
+					logger.Warn().Err(err).Msg("no tuner available")
+					return nil
+				}
+
+				// get transcode cmd
+				cmd, err := a.transcodeStart(profilePath, input)
+				if err != nil {
+					logger.Error().Err(err).Msg("transcode could not be started")
+					a.tunerRelease("llhls/" + ID)
+				}
+
+				return cmd
+			})
+
+			manager.OnStop(func(err error) {
+				a.tunerRelease("llhls/" + ID)
+			})
+
+			llhlsManagers[ID] = manager
+		}
+		llhlsManagersMu.Unlock()
+
+		manager.ServePlaylist(w, r)
+	})
+
+	r.Get(llhlsPrefix+"{profile}/{input}/{file}", func(w http.ResponseWriter, r *http.Request) {
+		profile := chi.URLParam(r, "profile")
+		input := chi.URLParam(r, "input")
+		file := chi.URLParam(r, "file")
+
+		if file == "play.html" {
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte(playHTML))
+			return
+		}
+
+		if !resourceRegex.MatchString(profile) || !streamIdRegex.MatchString(input) || !mediaRegex.MatchString(file) {
+			http.Error(w, "400 invalid parameters", http.StatusBadRequest)
+			return
+		}
+
+		ID := fmt.Sprintf("%s/%s", profile, input)
+
+		llhlsManagersMu.RLock()
+		manager, ok := llhlsManagers[ID]
+		llhlsManagersMu.RUnlock()
+
+		if !ok {
+			http.Error(w, "404 transcode not found", http.StatusNotFound)
+			return
+		}
+
+		manager.ServeMedia(w, r)
+	})
+}
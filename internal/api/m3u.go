@@ -0,0 +1,90 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// characters breaking #EXTINF attributes or title
+var m3uEscaper = strings.NewReplacer("\"", "'", "\n", " ", "\r", " ")
+
+func (a *ApiManagerCtx) Playlist(r chi.Router) {
+	// all streams as IPTV playlist, e.g. /playlist.m3u?profile=h264_720p&type=hls
+	r.Get("/playlist.m3u", func(w http.ResponseWriter, r *http.Request) {
+		profile := r.URL.Query().Get("profile")
+		if profile == "" {
+			http.Error(w, "400 missing profile", http.StatusBadRequest)
+			return
+		}
+
+		kind := r.URL.Query().Get("type")
+		if kind == "" {
+			kind = "hls"
+		}
+
+		if kind != "hls" && kind != "http" {
+			http.Error(w, "400 type must be hls or http", http.StatusBadRequest)
+			return
+		}
+
+		if _, err := a.ProfilePath(kind, profile); err != nil {
+			http.Error(w, "404 profile not found", http.StatusNotFound)
+			return
+		}
+
+		baseUrl := requestBaseUrl(r, a.config.Proxy)
+
+		var b strings.Builder
+		if a.epgEnabled() {
+			b.WriteString(fmt.Sprintf("#EXTM3U url-tvg=\"%s/epg.xml\"\n", baseUrl))
+		} else {
+			b.WriteString("#EXTM3U\n")
+		}
+
+		for _, id := range a.config.StreamIDs() {
+			// streams with IDs not allowed in urls could not be played
+			if !streamIdRegex.MatchString(id) {
+				continue
+			}
+
+			meta := a.config.StreamMeta(id)
+
+			name := meta.Name
+			if name == "" {
+				name = id
+			}
+
+			// channels in epg are keyed by stream IDs
+			b.WriteString(fmt.Sprintf("#EXTINF:-1 tvg-id=\"%s\" tvg-name=\"%s\"", m3uEscaper.Replace(id), m3uEscaper.Replace(name)))
+			if logo := a.streamLogoUrl(baseUrl, id, meta); logo != "" {
+				b.WriteString(fmt.Sprintf(" tvg-logo=\"%s\"", m3uEscaper.Replace(logo)))
+			}
+
+			// streams imported from Enigma2 are grouped by bouquet
+			group := meta.Group
+			if group == "" {
+				group = meta.BouquetName
+			}
+			if group != "" {
+				b.WriteString(fmt.Sprintf(" group-title=\"%s\"", m3uEscaper.Replace(group)))
+			}
+			if meta.Radio {
+				b.WriteString(" radio=\"true\"")
+			}
+			b.WriteString("," + m3uEscaper.Replace(name) + "\n")
+
+			url := baseUrl + "/" + profile + "/" + neturl.PathEscape(id)
+			if kind == "hls" {
+				url += "/index.m3u8"
+			}
+			b.WriteString(url + "\n")
+		}
+
+		w.Header().Set("Content-Type", "audio/x-mpegurl")
+		_, _ = w.Write([]byte(b.String()))
+	})
+}
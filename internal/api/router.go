@@ -7,6 +7,7 @@ import (
 	"os/exec"
 	"path"
 	"regexp"
+	"sync"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/rs/zerolog/log"
@@ -16,35 +17,87 @@ import (
 
 var resourceRegex = regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
 
+// stream IDs can contain dots, e.g. sat.1 imported from Enigma2
+var streamIdRegex = regexp.MustCompile(`^[0-9A-Za-z_-][0-9A-Za-z_.-]*$`)
+var mediaRegex = regexp.MustCompile(`^[0-9A-Za-z_-]+\.(m4s|mp4)$`)
+
 type ApiManagerCtx struct {
-	config *config.Server
+	config   *config.Server
+	shutdown chan struct{}
+
+	// stops refresh of stream sources, they are replaced on config reload
+	sourcesStop chan struct{}
+	sourcesMu   sync.Mutex
+
+	// requests EPG rebuild, e.g. when streams were synced
+	epgRebuild chan struct{}
 }
 
 func New(config *config.Server) *ApiManagerCtx {
 	return &ApiManagerCtx{
-		config: config,
+		config:     config,
+		shutdown:   make(chan struct{}),
+		epgRebuild: make(chan struct{}, 1),
 	}
 }
 
 func (manager *ApiManagerCtx) Start() {
+	if manager.config.Thumbnails.Refresh {
+		go manager.thumbnailsRefresh(manager.shutdown)
+	}
+
+	manager.SourcesRestart()
+
+	if manager.epgEnabled() {
+		go manager.epgRefresh(manager.shutdown)
+	}
+
+	if manager.config.HDHomeRun.Enabled && manager.config.HDHomeRun.SSDP {
+		go manager.hdhomerunSSDP(manager.shutdown)
+	}
 }
 
 func (manager *ApiManagerCtx) Shutdown() error {
+	close(manager.shutdown)
+	manager.sourcesStopAll()
+
 	// stop all hls managers
 	for _, hls := range hlsManagers {
 		hls.Stop()
 	}
 
+	// stop all low latency hls managers
+	llhlsManagersMu.RLock()
+	for _, hls := range llhlsManagers {
+		hls.Stop()
+	}
+	llhlsManagersMu.RUnlock()
+
 	// stop all hls vod managers
 	for _, hls := range hlsVodManagers {
 		hls.Stop()
 	}
 
+	// stop all hls proxy transcode managers
+	for _, hls := range hlsProxyTranscodeManagers {
+		hls.Stop()
+	}
+
 	// shutdown all hls proxy managers
 	for _, hls := range hlsProxyManagers {
 		hls.Shutdown()
 	}
 
+	// shutdown all dynamic hls proxy managers
+	for _, source := range hlsProxyDynamicSources {
+		source.manager.Shutdown()
+	}
+
+	// shutdown all dash proxy managers
+	for _, dash := range dashProxyManagers {
+		dash.Shutdown()
+	}
+
 	return nil
 }
 
@@ -56,18 +109,87 @@ func (a *ApiManagerCtx) Mount(r *chi.Mux) {
 
 	if a.config.Vod.MediaDir != "" {
 		r.Group(a.HlsVod)
+		r.Group(a.VodLibrary)
 		log.Info().Str("vod-dir", a.config.Vod.MediaDir).Msg("static file transcoding is active")
 	}
 
 	if len(a.config.HlsProxy) > 0 {
 		r.Group(a.HLSProxy)
-		log.Info().Interface("hls-proxy", a.config.HlsProxy).Msg("hls proxy is active")
+
+		// do not log credentials
+		sources := map[string]string{}
+		for ID, conf := range a.config.HlsProxy {
+			sources[ID] = conf.Url
+		}
+		log.Info().Interface("hls-proxy", sources).Msg("hls proxy is active")
+	}
+
+	if a.config.HlsProxyDynamic.Enabled {
+		r.Group(a.HLSProxyDynamic)
+		log.Info().
+			Strs("allow-hosts", a.config.HlsProxyDynamic.AllowHosts).
+			Strs("allow-cidrs", a.config.HlsProxyDynamic.AllowCidrs).
+			Msg("dynamic hls proxy is active")
+	}
+
+	if len(a.config.DashProxy) > 0 {
+		r.Group(a.DashProxy)
+
+		// do not log credentials
+		sources := map[string]string{}
+		for ID, conf := range a.config.DashProxy {
+			sources[ID] = conf.Url
+		}
+		log.Info().Interface("dash-proxy", sources).Msg("dash proxy is active")
 	}
 
+	r.Group(a.Playlist)
+	r.Group(a.Streams)
+
+	if a.epgEnabled() {
+		r.Group(a.EPG)
+	}
+
+	if a.config.Xtream.Enabled {
+		r.Group(a.Xtream)
+		log.Info().Int("users", len(a.config.Xtream.Users)).Msg("xtream codes api is active")
+	}
+
+	if a.config.HDHomeRun.Enabled {
+		r.Group(a.HDHomeRun)
+		log.Info().
+			Str("device-id", a.config.HDHomeRun.DeviceID).
+			Int("tuners", a.config.HDHomeRun.Tuners).
+			Msg("hdhomerun emulation is active")
+	}
+
+	r.Group(a.LLHLS)
 	r.Group(a.HLS)
+	r.Group(a.Thumbnail)
+	r.Group(a.Icecast)
 	r.Group(a.Http)
 }
 
+// scheme and host, as requested by client
+func requestBaseUrl(r *http.Request, behindProxy bool) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	host := r.Host
+	if behindProxy {
+		if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if fwdHost := r.Header.Get("X-Forwarded-Host"); fwdHost != "" {
+			host = fwdHost
+		}
+	}
+
+	return scheme + "://" + host
+}
+
 func (a *ApiManagerCtx) ProfilePath(folder string, profile string) (string, error) {
 	// [profiles]/hls,http/[profile].sh
 	// [profiles] defaults to [basedir]/profiles
@@ -85,7 +207,7 @@ func (a *ApiManagerCtx) ProfilePath(folder string, profile string) (string, erro
 
 // Call ProfilePath before
 func (a *ApiManagerCtx) transcodeStart(profilePath string, input string) (*exec.Cmd, error) {
-	url, ok := a.config.Streams[input]
+	url, ok := a.config.Stream(input)
 	if !ok {
 		return nil, fmt.Errorf("stream not found")
 	}
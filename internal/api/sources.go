@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/m1k1o/go-transcode/internal/sources"
+)
+
+// how soon is failed source sync retried
+const sourceRetryInterval = time.Minute
+
+// SourcesRestart starts refresh of currently configured sources, refresh
+// of previously configured ones is stopped. Called when config is reloaded.
+func (a *ApiManagerCtx) SourcesRestart() {
+	a.sourcesMu.Lock()
+	defer a.sourcesMu.Unlock()
+
+	if a.sourcesStop != nil {
+		close(a.sourcesStop)
+	}
+
+	a.sourcesStop = make(chan struct{})
+	for _, source := range a.config.StreamSources() {
+		go a.sourceRefresh(source, a.sourcesStop)
+	}
+}
+
+func (a *ApiManagerCtx) sourcesStopAll() {
+	a.sourcesMu.Lock()
+	defer a.sourcesMu.Unlock()
+
+	if a.sourcesStop != nil {
+		close(a.sourcesStop)
+		a.sourcesStop = nil
+	}
+}
+
+// periodically re-sync streams from source, failed sync is retried sooner
+func (a *ApiManagerCtx) sourceRefresh(source sources.Source, shutdown chan struct{}) {
+	logger := log.With().Str("module", "sources").Str("source", source.Name()).Logger()
+
+	// initial sync was done when loading config
+	synced := a.config.StreamsLoaded(source.Name())
+
+	for {
+		wait := source.Refresh()
+		if !synced {
+			wait = sourceRetryInterval
+		} else if wait <= 0 {
+			return
+		}
+
+		select {
+		case <-shutdown:
+			return
+		case <-time.After(wait):
+		}
+
+		// sync is aborted on shutdown
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			select {
+			case <-shutdown:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		// previously imported streams are kept on error
+		err := a.config.LoadSource(ctx, source)
+		cancel()
+
+		if err != nil {
+			logger.Warn().Err(err).Dur("retry", sourceRetryInterval).Msg("unable to sync streams")
+			synced = false
+		} else {
+			synced = true
+
+			// programmes are mapped to synced streams
+			a.epgRebuildRequest()
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+
+	"github.com/m1k1o/go-transcode/internal/config"
+	"github.com/m1k1o/go-transcode/internal/sources"
+)
+
+// how long is missing picon not requested again from receiver
+const piconMissingExpiration = 10 * time.Minute
+
+type picon struct {
+	data    []byte // nil if missing
+	expires time.Time
+}
+
+var picons = map[string]picon{}
+var piconsMu sync.Mutex
+
+type streamInfo struct {
+	Id         string `json:"id"`
+	Name       string `json:"name"`
+	Source     string `json:"source,omitempty"` // empty for configured streams
+	Radio      bool   `json:"radio"`
+	Group      string `json:"group,omitempty"`
+	Provider   string `json:"provider,omitempty"`
+	Bouquet    string `json:"bouquet,omitempty"`
+	BouquetRef string `json:"bouquet_reference,omitempty"`
+	Reference  string `json:"reference,omitempty"`
+	TvgId      string `json:"tvg_id,omitempty"`
+	Logo       string `json:"logo,omitempty"`
+	Thumbnail  string `json:"thumbnail"`
+}
+
+func (a *ApiManagerCtx) Streams(r chi.Router) {
+	r.Get("/api/streams", func(w http.ResponseWriter, r *http.Request) {
+		baseUrl := requestBaseUrl(r, a.config.Proxy)
+
+		// upstream urls are not listed, they may contain credentials
+		streams := []streamInfo{}
+		for _, id := range a.config.StreamIDs() {
+			meta := a.config.StreamMeta(id)
+
+			name := meta.Name
+			if name == "" {
+				name = id
+			}
+
+			streams = append(streams, streamInfo{
+				Id:         id,
+				Name:       name,
+				Source:     meta.Source,
+				Radio:      meta.Radio,
+				Group:      meta.Group,
+				Provider:   meta.Provider,
+				Bouquet:    meta.BouquetName,
+				BouquetRef: meta.Bouquet,
+				Reference:  meta.Reference,
+				TvgId:      meta.TvgId,
+				Logo:       a.streamLogoUrl(baseUrl, id, meta),
+				Thumbnail:  baseUrl + "/" + url.PathEscape(id) + "/thumbnail.jpg",
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(streams)
+	})
+
+	r.Get("/logos/{stream}.png", func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "stream")
+
+		if _, ok := a.config.Stream(id); !ok {
+			http.Error(w, "404 stream not found", http.StatusNotFound)
+			return
+		}
+
+		meta := a.config.StreamMeta(id)
+		if data, ok := a.picon(meta); ok {
+			w.Header().Set("Content-Type", "image/png")
+			w.Header().Set("Cache-Control", "public, max-age=86400")
+			_, _ = w.Write(data)
+			return
+		}
+
+		// logo from imported playlist
+		if meta.Logo != "" {
+			http.Redirect(w, r, meta.Logo, http.StatusFound)
+			return
+		}
+
+		http.Error(w, "404 logo not found", http.StatusNotFound)
+	})
+}
+
+func (a *ApiManagerCtx) piconsEnabled() bool {
+	return a.config.Enigma2.PiconDir != "" || (a.config.Enigma2.Picons && a.config.Enigma2.WebifUrl != "")
+}
+
+// logo served by us if picons are available, otherwise imported one
+func (a *ApiManagerCtx) streamLogoUrl(baseUrl string, id string, meta config.StreamMeta) string {
+	if (a.piconsEnabled() && meta.Reference != "") || a.localPicon(meta) != "" {
+		return baseUrl + "/logos/" + url.PathEscape(id) + ".png"
+	}
+	return meta.Logo
+}
+
+// picon names by service reference and by service name
+func piconNames(meta config.StreamMeta) []string {
+	names := []string{}
+	if meta.Reference != "" {
+		names = append(names, sources.Enigma2PiconName(meta.Reference))
+	}
+	if meta.Name != "" {
+		names = append(names, sources.Enigma2PiconSnpName(meta.Name))
+	}
+	return names
+}
+
+// path to picon in local directory, if exists
+func (a *ApiManagerCtx) localPicon(meta config.StreamMeta) string {
+	dir := a.config.Enigma2.PiconDir
+	if dir == "" {
+		return ""
+	}
+
+	for _, name := range piconNames(meta) {
+		file := filepath.Join(dir, name+".png")
+		if _, err := os.Stat(file); err == nil {
+			return file
+		}
+	}
+
+	return ""
+}
+
+// get picon from local directory or from receiver
+func (a *ApiManagerCtx) picon(meta config.StreamMeta) ([]byte, bool) {
+	if file := a.localPicon(meta); file != "" {
+		if data, err := os.ReadFile(file); err == nil {
+			return data, true
+		}
+	}
+
+	if !a.config.Enigma2.Picons || a.config.Enigma2.WebifUrl == "" || meta.Reference == "" {
+		return nil, false
+	}
+
+	// only reference based picons are served by receiver
+	name := sources.Enigma2PiconName(meta.Reference)
+
+	piconsMu.Lock()
+	cached, ok := picons[name]
+	piconsMu.Unlock()
+
+	if ok && (cached.data != nil || time.Now().Before(cached.expires)) {
+		return cached.data, cached.data != nil
+	}
+
+	data, err := a.piconFetch(name)
+	if err != nil {
+		log.Debug().Err(err).Str("picon", name).Msg("unable to get picon from receiver")
+		data = nil
+	}
+
+	piconsMu.Lock()
+	picons[name] = picon{data, time.Now().Add(piconMissingExpiration)}
+	piconsMu.Unlock()
+
+	return data, data != nil
+}
+
+func (a *ApiManagerCtx) piconFetch(name string) ([]byte, error) {
+	webifUrl, err := url.Parse(a.config.Enigma2.WebifUrl)
+	if err != nil {
+		return nil, err
+	}
+	webifUrl.Path = path.Join(webifUrl.Path, "/picon", name+".png")
+
+	client := http.Client{Timeout: 10 * time.Second}
+
+	resp, err := client.Get(webifUrl.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status error: %d", resp.StatusCode)
+	}
+
+	// picons are small, do not read more than needed
+	return io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+}
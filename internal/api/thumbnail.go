@@ -0,0 +1,169 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// how long can it take to grab a single frame
+const thumbnailTimeout = 15 * time.Second
+
+type thumbnail struct {
+	mu      sync.Mutex
+	data    []byte
+	expires time.Time
+}
+
+var thumbnails = map[string]*thumbnail{}
+var thumbnailsMu sync.Mutex
+
+func (a *ApiManagerCtx) Thumbnail(r chi.Router) {
+	r.Get("/{input}/thumbnail.jpg", func(w http.ResponseWriter, r *http.Request) {
+		logger := log.With().Str("module", "thumbnail").Logger()
+
+		input := chi.URLParam(r, "input")
+		if !streamIdRegex.MatchString(input) {
+			http.Error(w, "400 invalid parameters", http.StatusBadRequest)
+			return
+		}
+
+		// check if stream exists
+		if _, ok := a.config.Stream(input); !ok {
+			http.Error(w, "404 stream not found", http.StatusNotFound)
+			return
+		}
+
+		data, err := a.thumbnail(r.Context(), input)
+		if err != nil {
+			logger.Warn().Err(err).Str("input", input).Msg("unable to grab thumbnail")
+			http.Error(w, "500 thumbnail not available", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(a.config.Thumbnails.Interval.Seconds())))
+		_, _ = w.Write(data)
+	})
+}
+
+// get cached thumbnail or grab a new one
+func (a *ApiManagerCtx) thumbnail(ctx context.Context, input string) ([]byte, error) {
+	thumbnailsMu.Lock()
+	entry, ok := thumbnails[input]
+	if !ok {
+		entry = &thumbnail{}
+		thumbnails[input] = entry
+	}
+	thumbnailsMu.Unlock()
+
+	// only one grab per input at a time
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	if entry.data != nil && time.Now().Before(entry.expires) {
+		return entry.data, nil
+	}
+
+	data, err := a.thumbnailGrab(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	entry.data = data
+	entry.expires = time.Now().Add(a.config.Thumbnails.Interval)
+	return data, nil
+}
+
+// grab a frame from running transcode, or from the source directly
+func (a *ApiManagerCtx) thumbnailGrab(ctx context.Context, input string) ([]byte, error) {
+	source, ok := "", false
+
+	hlsManagersMu.RLock()
+	for ID, manager := range hlsManagers {
+		if !strings.HasSuffix(ID, "/"+input) {
+			continue
+		}
+
+		if source, ok = manager.LatestSegment(); ok {
+			break
+		}
+	}
+	hlsManagersMu.RUnlock()
+
+	if !ok {
+		source, ok = a.config.Stream(input)
+		if !ok {
+			return nil, fmt.Errorf("stream not found")
+		}
+
+		// grabbing from source needs a tuner
+		sessionID := "thumbnail/" + input
+		if err := a.tunerAcquire(ctx, sessionID, input); err != nil {
+			return nil, err
+		}
+		defer a.tunerRelease(sessionID)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, thumbnailTimeout)
+	defer cancel()
+
+	args := []string{
+		"-hide_banner", "-loglevel", "error",
+		"-i", source,
+		"-frames:v", "1",
+	}
+
+	if a.config.Thumbnails.Width > 0 {
+		args = append(args, "-vf", fmt.Sprintf("scale=%d:-2", a.config.Thumbnails.Width))
+	}
+
+	args = append(args, "-f", "image2", "-c:v", "mjpeg", "-")
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, a.config.Thumbnails.FFmpegBinary, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// periodically refresh thumbnails of all streams
+func (a *ApiManagerCtx) thumbnailsRefresh(shutdown chan struct{}) {
+	logger := log.With().Str("module", "thumbnail").Logger()
+
+	ticker := time.NewTicker(a.config.Thumbnails.Interval)
+	defer ticker.Stop()
+
+	for {
+		for _, input := range a.config.StreamIDs() {
+			select {
+			case <-shutdown:
+				return
+			default:
+			}
+
+			if _, err := a.thumbnail(context.Background(), input); err != nil {
+				logger.Warn().Err(err).Str("input", input).Msg("unable to refresh thumbnail")
+			}
+		}
+
+		select {
+		case <-shutdown:
+			return
+		case <-ticker.C:
+		}
+	}
+}
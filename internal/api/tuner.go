@@ -0,0 +1,165 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/m1k1o/go-transcode/internal/sources"
+)
+
+// live session using Enigma2 tuner
+type tunerSession struct {
+	input       string
+	transponder string
+}
+
+var tunerSessions = map[string]tunerSession{}
+var tunersMu sync.Mutex
+
+// closed and replaced whenever tuner is released
+var tunersReleased = make(chan struct{})
+
+type tunerBusyError struct {
+	tuners   int
+	input    string
+	blocking []string // channels occupying tuners
+}
+
+func (e *tunerBusyError) Error() string {
+	return fmt.Sprintf("all %d tuners are in use by %s, channel %s needs another one", e.tuners, strings.Join(e.blocking, ", "), e.input)
+}
+
+// register live session of input, if it needs a tuner that is not available, wait up to
+// configured time for another session to end. Sessions are identified by ID, acquiring
+// the same session again is no-op.
+func (a *ApiManagerCtx) tunerAcquire(ctx context.Context, sessionID string, input string) error {
+	tuners := a.config.Enigma2.Tuners
+	if tuners <= 0 {
+		return nil
+	}
+
+	meta := a.config.StreamMeta(input)
+	if meta.Source != "enigma2" {
+		return nil
+	}
+
+	// IPTV services do not need tuner
+	transponder := sources.Enigma2Transponder(meta.Reference)
+	if transponder == "" {
+		return nil
+	}
+
+	var timeout <-chan time.Time
+	if wait := a.config.Enigma2.TunerWait; wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for {
+		tunersMu.Lock()
+		err := a.tunerTryAcquire(sessionID, input, transponder, tuners)
+		released := tunersReleased
+		tunersMu.Unlock()
+
+		if err == nil || timeout == nil {
+			return err
+		}
+
+		select {
+		case <-released:
+		case <-timeout:
+			return err
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// register live session of input without waiting, e.g. when transcode is restarted
+func (a *ApiManagerCtx) tunerAcquireNow(sessionID string, input string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	return a.tunerAcquire(ctx, sessionID, input)
+}
+
+// tunersMu must be held
+func (a *ApiManagerCtx) tunerTryAcquire(sessionID string, input string, transponder string, tuners int) error {
+	if _, ok := tunerSessions[sessionID]; ok {
+		return nil
+	}
+
+	// channels by occupied transponder
+	transponders := map[string][]string{}
+	for _, session := range tunerSessions {
+		transponders[session.transponder] = append(transponders[session.transponder], session.input)
+	}
+
+	// services on the same transponder share tuner
+	if _, ok := transponders[transponder]; !ok && len(transponders) >= tuners {
+		blocking := []string{}
+		for _, inputs := range transponders {
+			for _, input := range inputs {
+				blocking = append(blocking, a.streamName(input))
+			}
+		}
+		sort.Strings(blocking)
+
+		return &tunerBusyError{
+			tuners:   tuners,
+			input:    a.streamName(input),
+			blocking: uniqueStrings(blocking),
+		}
+	}
+
+	tunerSessions[sessionID] = tunerSession{
+		input:       input,
+		transponder: transponder,
+	}
+
+	return nil
+}
+
+func (a *ApiManagerCtx) tunerRelease(sessionID string) {
+	tunersMu.Lock()
+	defer tunersMu.Unlock()
+
+	if _, ok := tunerSessions[sessionID]; !ok {
+		return
+	}
+
+	delete(tunerSessions, sessionID)
+
+	// wake up waiting sessions
+	close(tunersReleased)
+	tunersReleased = make(chan struct{})
+}
+
+func (a *ApiManagerCtx) streamName(input string) string {
+	if name := a.config.StreamMeta(input).Name; name != "" {
+		return name
+	}
+	return input
+}
+
+func tunerError(w http.ResponseWriter, err error) {
+	w.Header().Set("Retry-After", "30")
+	http.Error(w, "503 "+err.Error(), http.StatusServiceUnavailable)
+}
+
+// sorted strings without duplicates
+func uniqueStrings(list []string) []string {
+	out := []string{}
+	for i, s := range list {
+		if i == 0 || list[i-1] != s {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,279 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// default and maximum number of entries in single listing
+const vodListLimit = 100
+const vodListMaxLimit = 1000
+
+type vodMediaInfo struct {
+	Duration    float64  `json:"duration"` // in seconds
+	Width       int      `json:"width,omitempty"`
+	Height      int      `json:"height,omitempty"`
+	AudioTracks int      `json:"audio_tracks"`
+	Formats     []string `json:"formats,omitempty"`
+}
+
+type vodEntry struct {
+	Name     string        `json:"name"`
+	Path     string        `json:"path"` // relative to media dir
+	Dir      bool          `json:"dir"`
+	Size     int64         `json:"size,omitempty"`
+	Modified time.Time     `json:"modified"`
+	Url      string        `json:"url,omitempty"`   // hls master playlist
+	Media    *vodMediaInfo `json:"media,omitempty"` // only if already probed
+}
+
+type vodListing struct {
+	Path    string     `json:"path"`
+	Parent  *string    `json:"parent"` // null in media dir root
+	Query   string     `json:"query,omitempty"`
+	Total   int        `json:"total"`
+	Offset  int        `json:"offset"`
+	Limit   int        `json:"limit"`
+	Entries []vodEntry `json:"entries"`
+}
+
+var vodSorts = map[string]func(a, b vodEntry) bool{
+	"name": func(a, b vodEntry) bool {
+		return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+	},
+	"size": func(a, b vodEntry) bool {
+		return a.Size < b.Size
+	},
+	"modified": func(a, b vodEntry) bool {
+		return a.Modified.Before(b.Modified)
+	},
+}
+
+func (a *ApiManagerCtx) VodLibrary(r chi.Router) {
+	// e.g. /api/vod?path=movies&q=matrix&sort=modified&order=desc&offset=0&limit=100
+	r.Get("/api/vod", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		// never leave media dir
+		relPath := strings.TrimPrefix(path.Clean("/"+query.Get("path")), "/")
+		dir := filepath.Join(a.config.Vod.MediaDir, filepath.FromSlash(relPath))
+
+		stat, err := os.Stat(dir)
+		if err != nil {
+			http.Error(w, "404 path not found", http.StatusNotFound)
+			return
+		}
+		if !stat.IsDir() {
+			http.Error(w, "400 path is not directory", http.StatusBadRequest)
+			return
+		}
+
+		sortBy := query.Get("sort")
+		if sortBy == "" {
+			sortBy = "name"
+		}
+		less, ok := vodSorts[sortBy]
+		if !ok {
+			http.Error(w, "400 sort must be name, size or modified", http.StatusBadRequest)
+			return
+		}
+
+		desc := false
+		switch query.Get("order") {
+		case "", "asc":
+		case "desc":
+			desc = true
+		default:
+			http.Error(w, "400 order must be asc or desc", http.StatusBadRequest)
+			return
+		}
+
+		offset, _ := strconv.Atoi(query.Get("offset"))
+		if offset < 0 {
+			offset = 0
+		}
+
+		limit, _ := strconv.Atoi(query.Get("limit"))
+		if limit <= 0 {
+			limit = vodListLimit
+		}
+		if limit > vodListMaxLimit {
+			limit = vodListMaxLimit
+		}
+
+		search := strings.TrimSpace(query.Get("q"))
+
+		var entries []vodEntry
+		if search != "" {
+			entries, err = a.vodSearch(relPath, search)
+		} else {
+			entries, err = a.vodList(relPath)
+		}
+
+		if err != nil {
+			http.Error(w, "500 unable to list directory", http.StatusInternalServerError)
+			return
+		}
+
+		// directories first, then by selected field
+		sort.SliceStable(entries, func(i, j int) bool {
+			if entries[i].Dir != entries[j].Dir {
+				return entries[i].Dir
+			}
+			if desc {
+				return less(entries[j], entries[i])
+			}
+			return less(entries[i], entries[j])
+		})
+
+		listing := vodListing{
+			Path:    relPath,
+			Query:   search,
+			Total:   len(entries),
+			Offset:  offset,
+			Limit:   limit,
+			Entries: []vodEntry{},
+		}
+
+		if relPath != "" {
+			parent := path.Dir(relPath)
+			if parent == "." {
+				parent = ""
+			}
+			listing.Parent = &parent
+		}
+
+		if offset < len(entries) {
+			end := offset + limit
+			if end > len(entries) {
+				end = len(entries)
+			}
+			listing.Entries = entries[offset:end]
+		}
+
+		// only returned entries get metadata
+		baseUrl := requestBaseUrl(r, a.config.Proxy)
+		for i, entry := range listing.Entries {
+			if !entry.Dir {
+				listing.Entries[i] = a.vodEntryMedia(baseUrl, entry)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(listing)
+	})
+}
+
+// directories and media files in directory
+func (a *ApiManagerCtx) vodList(relPath string) ([]vodEntry, error) {
+	dir := filepath.Join(a.config.Vod.MediaDir, filepath.FromSlash(relPath))
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []vodEntry{}
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), ".") || (!file.IsDir() && !vodIsMedia(file.Name())) {
+			continue
+		}
+
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+
+		entry := vodEntry{
+			Name:     file.Name(),
+			Path:     path.Join(relPath, file.Name()),
+			Dir:      file.IsDir(),
+			Modified: info.ModTime(),
+		}
+		if !entry.Dir {
+			entry.Size = info.Size()
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// media files below directory, whose names contain all search words
+func (a *ApiManagerCtx) vodSearch(relPath string, search string) ([]vodEntry, error) {
+	files, err := a.vodFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	words := strings.Fields(strings.ToLower(search))
+
+	entries := []vodEntry{}
+	for _, file := range files {
+		if relPath != "" && !strings.HasPrefix(file, relPath+"/") {
+			continue
+		}
+
+		name := strings.ToLower(path.Base(file))
+
+		matches := true
+		for _, word := range words {
+			if !strings.Contains(name, word) {
+				matches = false
+				break
+			}
+		}
+		if !matches {
+			continue
+		}
+
+		info, err := os.Stat(filepath.Join(a.config.Vod.MediaDir, filepath.FromSlash(file)))
+		if err != nil {
+			continue
+		}
+
+		entries = append(entries, vodEntry{
+			Name:     path.Base(file),
+			Path:     file,
+			Size:     info.Size(),
+			Modified: info.ModTime(),
+		})
+	}
+
+	return entries, nil
+}
+
+// add playlist url and metadata, if media was already probed
+func (a *ApiManagerCtx) vodEntryMedia(baseUrl string, entry vodEntry) vodEntry {
+	vodUrl := neturl.URL{Path: "/vod/" + entry.Path + "/index.m3u8"}
+	entry.Url = baseUrl + vodUrl.EscapedPath()
+
+	mediaPath := filepath.Join(a.config.Vod.MediaDir, filepath.FromSlash(entry.Path))
+	data := a.vodProbeCached(mediaPath, entry.Modified)
+	if data == nil {
+		return entry
+	}
+
+	entry.Media = &vodMediaInfo{
+		Duration:    data.Duration.Seconds(),
+		AudioTracks: len(data.Audio),
+		Formats:     data.FormatName,
+	}
+	if data.Video != nil {
+		entry.Media.Width = data.Video.Width
+		entry.Media.Height = data.Video.Height
+	}
+
+	return entry
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/m1k1o/go-transcode/hlsvod"
+)
+
+// file extensions listed as VOD media
+var vodExtensions = map[string]bool{
+	".mp4":  true,
+	".m4v":  true,
+	".mkv":  true,
+	".mov":  true,
+	".avi":  true,
+	".wmv":  true,
+	".flv":  true,
+	".webm": true,
+	".ts":   true,
+	".m2ts": true,
+	".mpg":  true,
+	".mpeg": true,
+}
+
+type vodProbeEntry struct {
+	modTime time.Time
+	data    *hlsvod.ProbeMediaData
+}
+
+// how long is list of media files kept, so that library is not walked on every request
+const vodFilesExpiration = time.Minute
+
+var vodFilesCache []string
+var vodFilesExpires time.Time
+var vodFilesMu sync.Mutex
+
+// probed media by absolute path, invalidated when file changes
+var vodProbes = map[string]vodProbeEntry{}
+var vodProbesMu sync.Mutex
+
+func vodIsMedia(name string) bool {
+	return !strings.HasPrefix(name, ".") && vodExtensions[strings.ToLower(path.Ext(name))]
+}
+
+// all media files in media dir, as sorted slash separated relative paths, must not be modified
+func (a *ApiManagerCtx) vodFiles() ([]string, error) {
+	vodFilesMu.Lock()
+	defer vodFilesMu.Unlock()
+
+	if vodFilesCache != nil && time.Now().Before(vodFilesExpires) {
+		return vodFilesCache, nil
+	}
+
+	files, err := a.vodWalk()
+	if err == nil {
+		vodFilesCache = files
+		vodFilesExpires = time.Now().Add(vodFilesExpiration)
+	}
+
+	return files, err
+}
+
+func (a *ApiManagerCtx) vodWalk() ([]string, error) {
+	root := a.config.Vod.MediaDir
+
+	files := []string{}
+	err := filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if file == root {
+				return err
+			}
+
+			// unreadable entry does not hide the rest of media
+			log.Warn().Err(err).Str("module", "vod").Str("path", file).Msg("unable to read media")
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// skip hidden files and directories
+		if file != root && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.IsDir() || !vodIsMedia(d.Name()) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+
+	sort.Strings(files)
+	return files, err
+}
+
+// media metadata, probed with the same config as when served by /vod/
+func (a *ApiManagerCtx) vodProbe(ctx context.Context, mediaPath string) (*hlsvod.ProbeMediaData, error) {
+	stat, err := os.Stat(mediaPath)
+	if err != nil {
+		return nil, err
+	}
+
+	vodProbesMu.Lock()
+	entry, ok := vodProbes[mediaPath]
+	vodProbesMu.Unlock()
+
+	if ok && entry.modTime.Equal(stat.ModTime()) {
+		return entry.data, nil
+	}
+
+	data, err := hlsvod.New(hlsvod.Config{
+		MediaPath:      mediaPath,
+		VideoKeyframes: a.config.Vod.VideoKeyframes,
+
+		Cache:    a.config.Vod.Cache,
+		CacheDir: a.config.Vod.CacheDir,
+
+		FFmpegBinary:  a.config.Vod.FFmpegBinary,
+		FFprobeBinary: a.config.Vod.FFprobeBinary,
+	}).Preload(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	vodProbesMu.Lock()
+	vodProbes[mediaPath] = vodProbeEntry{stat.ModTime(), data}
+	vodProbesMu.Unlock()
+
+	return data, nil
+}
+
+// media metadata probed before, from memory or from hlsvod cache, media is never probed
+func (a *ApiManagerCtx) vodProbeCached(mediaPath string, modTime time.Time) *hlsvod.ProbeMediaData {
+	vodProbesMu.Lock()
+	entry, ok := vodProbes[mediaPath]
+	vodProbesMu.Unlock()
+
+	if ok && entry.modTime.Equal(modTime) {
+		return entry.data
+	}
+
+	if !a.config.Vod.Cache {
+		return nil
+	}
+
+	data, err := hlsvod.CachedMetadata(mediaPath, a.config.Vod.CacheDir)
+	if err != nil {
+		return nil
+	}
+
+	vodProbesMu.Lock()
+	vodProbes[mediaPath] = vodProbeEntry{modTime, data}
+	vodProbesMu.Unlock()
+
+	return data
+}
@@ -0,0 +1,510 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	neturl "net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// category of streams without group
+const xtreamDefaultCategory = "Live"
+
+// category of media in root of media dir
+const xtreamDefaultVodCategory = "Movies"
+
+// media files by numeric ID, so that library is not walked on every request
+var xtreamVodsList []xtreamVod
+var xtreamVodsById map[int]xtreamVod
+var xtreamVodsExpires time.Time
+var xtreamVodsMu sync.Mutex
+
+type xtreamCategory struct {
+	CategoryId   string `json:"category_id"`
+	CategoryName string `json:"category_name"`
+	ParentId     int    `json:"parent_id"`
+}
+
+type xtreamLiveStream struct {
+	Num               int    `json:"num"`
+	Name              string `json:"name"`
+	StreamType        string `json:"stream_type"`
+	StreamId          int    `json:"stream_id"`
+	StreamIcon        string `json:"stream_icon"`
+	EpgChannelId      string `json:"epg_channel_id"`
+	Added             string `json:"added"`
+	CategoryId        string `json:"category_id"`
+	CustomSid         string `json:"custom_sid"`
+	TvArchive         int    `json:"tv_archive"`
+	DirectSource      string `json:"direct_source"`
+	TvArchiveDuration int    `json:"tv_archive_duration"`
+
+	group string // category name
+}
+
+type xtreamVodStream struct {
+	Num                int    `json:"num"`
+	Name               string `json:"name"`
+	StreamType         string `json:"stream_type"`
+	StreamId           int    `json:"stream_id"`
+	StreamIcon         string `json:"stream_icon"`
+	Rating             string `json:"rating"`
+	Added              string `json:"added"`
+	CategoryId         string `json:"category_id"`
+	ContainerExtension string `json:"container_extension"`
+	CustomSid          string `json:"custom_sid"`
+	DirectSource       string `json:"direct_source"`
+}
+
+type xtreamVod struct {
+	id       int
+	path     string // relative to media dir
+	name     string
+	category string
+}
+
+func (a *ApiManagerCtx) Xtream(r chi.Router) {
+	playerApi := func(w http.ResponseWriter, r *http.Request) {
+		username, ok := a.xtreamAuth(r)
+		if !ok {
+			// apps expect user info even if login failed
+			xtreamJson(w, map[string]interface{}{
+				"user_info": map[string]interface{}{"auth": 0},
+			})
+			return
+		}
+
+		baseUrl := requestBaseUrl(r, a.config.Proxy)
+
+		switch action := r.FormValue("action"); action {
+		case "":
+			xtreamJson(w, a.xtreamLogin(r, username))
+		case "get_live_categories":
+			xtreamJson(w, a.xtreamLiveCategories())
+		case "get_live_streams":
+			xtreamJson(w, a.xtreamLiveStreams(baseUrl, r.FormValue("category_id")))
+		case "get_vod_categories":
+			vods := a.xtreamVods()
+			categories := []xtreamCategory{}
+			seen := map[string]bool{}
+			for _, vod := range vods {
+				if !seen[vod.category] {
+					seen[vod.category] = true
+					categories = append(categories, xtreamCategoryOf(vod.category))
+				}
+			}
+			xtreamJson(w, categories)
+		case "get_vod_streams":
+			categoryId := r.FormValue("category_id")
+			streams := []xtreamVodStream{}
+			for _, vod := range a.xtreamVods() {
+				category := xtreamCategoryOf(vod.category)
+				if categoryId != "" && categoryId != category.CategoryId {
+					continue
+				}
+
+				streams = append(streams, xtreamVodStream{
+					Num:                len(streams) + 1,
+					Name:               vod.name,
+					StreamType:         "movie",
+					StreamId:           vod.id,
+					Added:              "0",
+					CategoryId:         category.CategoryId,
+					ContainerExtension: "m3u8",
+				})
+			}
+			xtreamJson(w, streams)
+		case "get_vod_info":
+			vodId, _ := strconv.Atoi(r.FormValue("vod_id"))
+			vod, ok := a.xtreamVod(vodId)
+			if !ok {
+				xtreamJson(w, map[string]interface{}{"info": []string{}, "movie_data": nil})
+				return
+			}
+
+			info := map[string]interface{}{
+				"name": vod.name,
+			}
+
+			data, err := a.vodProbe(r.Context(), path.Join(a.config.Vod.MediaDir, vod.path))
+			if err != nil {
+				log.Warn().Err(err).Str("module", "xtream").Str("path", vod.path).Msg("unable to probe media")
+			} else {
+				seconds := int(data.Duration.Seconds())
+				info["duration_secs"] = seconds
+				info["duration"] = fmt.Sprintf("%02d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+				if data.Video != nil {
+					info["video"] = map[string]int{"width": data.Video.Width, "height": data.Video.Height}
+				}
+				info["audio"] = map[string]int{"tracks": len(data.Audio)}
+			}
+
+			xtreamJson(w, map[string]interface{}{
+				"info": info,
+				"movie_data": map[string]interface{}{
+					"stream_id":           vod.id,
+					"name":                vod.name,
+					"added":               "0",
+					"category_id":         xtreamCategoryOf(vod.category).CategoryId,
+					"container_extension": "m3u8",
+				},
+			})
+		case "get_series_categories", "get_series":
+			// series are not supported
+			xtreamJson(w, []string{})
+		case "get_short_epg", "get_simple_data_table":
+			// apps use xmltv.php instead
+			xtreamJson(w, map[string]interface{}{"epg_listings": []string{}})
+		default:
+			http.Error(w, "400 unknown action "+action, http.StatusBadRequest)
+		}
+	}
+
+	r.Get("/player_api.php", playerApi)
+	r.Post("/player_api.php", playerApi)
+
+	// m3u playlist with credentials in stream urls
+	r.Get("/get.php", func(w http.ResponseWriter, r *http.Request) {
+		username, ok := a.xtreamAuth(r)
+		if !ok {
+			http.Error(w, "403 forbidden", http.StatusForbidden)
+			return
+		}
+
+		ext := "ts"
+		if r.FormValue("output") == "m3u8" || r.FormValue("output") == "hls" {
+			ext = "m3u8"
+		}
+
+		baseUrl := requestBaseUrl(r, a.config.Proxy)
+		credentials := neturl.PathEscape(username) + "/" + neturl.PathEscape(r.FormValue("password"))
+
+		var b strings.Builder
+		b.WriteString("#EXTM3U\n")
+
+		for _, stream := range a.xtreamLiveStreams(baseUrl, "") {
+			fmt.Fprintf(&b, "#EXTINF:-1 tvg-id=\"%s\" tvg-name=\"%s\" tvg-logo=\"%s\" group-title=\"%s\",%s\n",
+				m3uEscaper.Replace(stream.EpgChannelId),
+				m3uEscaper.Replace(stream.Name),
+				m3uEscaper.Replace(stream.StreamIcon),
+				m3uEscaper.Replace(stream.group),
+				m3uEscaper.Replace(stream.Name))
+			fmt.Fprintf(&b, "%s/live/%s/%d.%s\n", baseUrl, credentials, stream.StreamId, ext)
+		}
+
+		for _, vod := range a.xtreamVods() {
+			fmt.Fprintf(&b, "#EXTINF:-1 tvg-name=\"%s\" group-title=\"%s\",%s\n",
+				m3uEscaper.Replace(vod.name),
+				m3uEscaper.Replace(vod.category),
+				m3uEscaper.Replace(vod.name))
+			fmt.Fprintf(&b, "%s/movie/%s/%d.m3u8\n", baseUrl, credentials, vod.id)
+		}
+
+		w.Header().Set("Content-Type", "audio/x-mpegurl")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"playlist.m3u\"")
+		_, _ = w.Write([]byte(b.String()))
+	})
+
+	r.Get("/xmltv.php", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := a.xtreamAuth(r); !ok {
+			http.Error(w, "403 forbidden", http.StatusForbidden)
+			return
+		}
+
+		if !a.epgEnabled() {
+			http.Error(w, "404 epg not enabled", http.StatusNotFound)
+			return
+		}
+
+		http.Redirect(w, r, "/epg.xml", http.StatusFound)
+	})
+
+	// stream urls are redirected to our live and vod endpoints
+	r.Get("/live/{username}/{password}/{stream}", func(w http.ResponseWriter, r *http.Request) {
+		if !a.xtreamPathAuth(r) {
+			http.Error(w, "403 forbidden", http.StatusForbidden)
+			return
+		}
+
+		stream := chi.URLParam(r, "stream")
+		ext := path.Ext(stream)
+		streamId, err := strconv.Atoi(strings.TrimSuffix(stream, ext))
+		if err != nil {
+			http.Error(w, "400 invalid stream id", http.StatusBadRequest)
+			return
+		}
+
+		id, ok := a.xtreamStream(streamId)
+		if !ok {
+			http.Error(w, "404 stream not found", http.StatusNotFound)
+			return
+		}
+
+		switch ext {
+		case ".m3u8":
+			http.Redirect(w, r, "/"+neturl.PathEscape(a.config.Xtream.HlsProfile)+"/"+neturl.PathEscape(id)+"/index.m3u8", http.StatusFound)
+		case "", ".ts":
+			http.Redirect(w, r, "/"+neturl.PathEscape(a.config.Xtream.Profile)+"/"+neturl.PathEscape(id), http.StatusFound)
+		default:
+			http.Error(w, "400 unsupported output", http.StatusBadRequest)
+		}
+	})
+
+	if a.config.Vod.MediaDir != "" {
+		r.Get("/movie/{username}/{password}/{stream}", func(w http.ResponseWriter, r *http.Request) {
+			if !a.xtreamPathAuth(r) {
+				http.Error(w, "403 forbidden", http.StatusForbidden)
+				return
+			}
+
+			stream := chi.URLParam(r, "stream")
+			vodId, err := strconv.Atoi(strings.TrimSuffix(stream, path.Ext(stream)))
+			if err != nil {
+				http.Error(w, "400 invalid stream id", http.StatusBadRequest)
+				return
+			}
+
+			vod, ok := a.xtreamVod(vodId)
+			if !ok {
+				http.Error(w, "404 vod not found", http.StatusNotFound)
+				return
+			}
+
+			// media is always served as hls
+			vodUrl := neturl.URL{Path: "/vod/" + vod.path + "/index.m3u8"}
+			http.Redirect(w, r, vodUrl.EscapedPath(), http.StatusFound)
+		})
+	}
+}
+
+// credentials are sent as query or form values
+func (a *ApiManagerCtx) xtreamAuth(r *http.Request) (string, bool) {
+	username := r.FormValue("username")
+	return username, a.xtreamCheck(username, r.FormValue("password"))
+}
+
+// credentials are part of stream urls
+func (a *ApiManagerCtx) xtreamPathAuth(r *http.Request) bool {
+	username, err := neturl.PathUnescape(chi.URLParam(r, "username"))
+	if err != nil {
+		return false
+	}
+
+	password, err := neturl.PathUnescape(chi.URLParam(r, "password"))
+	if err != nil {
+		return false
+	}
+
+	return a.xtreamCheck(username, password)
+}
+
+func (a *ApiManagerCtx) xtreamCheck(username string, password string) bool {
+	// usernames are case insensitive, as config keys
+	expected, ok := a.config.Xtream.Users[strings.ToLower(username)]
+	if !ok || expected == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(password)) == 1
+}
+
+func (a *ApiManagerCtx) xtreamLogin(r *http.Request, username string) map[string]interface{} {
+	baseUrl, _ := neturl.Parse(requestBaseUrl(r, a.config.Proxy))
+
+	port := baseUrl.Port()
+	if port == "" {
+		port = "80"
+		if baseUrl.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	// tuners limit concurrent live streams
+	maxConnections := a.config.Enigma2.Tuners
+	if a.config.HDHomeRun.Enabled {
+		maxConnections = a.config.HDHomeRun.Tuners
+	}
+	if maxConnections <= 0 {
+		maxConnections = 100
+	}
+
+	now := time.Now().UTC()
+	return map[string]interface{}{
+		"user_info": map[string]interface{}{
+			"username":               username,
+			"password":               r.FormValue("password"),
+			"message":                "",
+			"auth":                   1,
+			"status":                 "Active",
+			"exp_date":               nil,
+			"is_trial":               "0",
+			"active_cons":            "0",
+			"created_at":             "0",
+			"max_connections":        strconv.Itoa(maxConnections),
+			"allowed_output_formats": []string{"m3u8", "ts"},
+		},
+		"server_info": map[string]interface{}{
+			"url":             baseUrl.Hostname(),
+			"port":            port,
+			"https_port":      port,
+			"server_protocol": baseUrl.Scheme,
+			"rtmp_port":       "0",
+			"timezone":        "UTC",
+			"timestamp_now":   now.Unix(),
+			"time_now":        now.Format("2006-01-02 15:04:05"),
+		},
+	}
+}
+
+// numeric ID required by apps, stable as long as stream ID or media path does not change
+func xtreamID(value string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(value))
+	return int(h.Sum32() & 0x7fffffff)
+}
+
+func xtreamCategoryOf(name string) xtreamCategory {
+	return xtreamCategory{
+		CategoryId:   strconv.Itoa(xtreamID(name)),
+		CategoryName: name,
+	}
+}
+
+func (a *ApiManagerCtx) xtreamStreamGroup(id string) string {
+	meta := a.config.StreamMeta(id)
+	if meta.Group != "" {
+		return meta.Group
+	}
+	if meta.BouquetName != "" {
+		return meta.BouquetName
+	}
+	return xtreamDefaultCategory
+}
+
+func (a *ApiManagerCtx) xtreamLiveCategories() []xtreamCategory {
+	categories := []xtreamCategory{}
+	seen := map[string]bool{}
+	for _, id := range a.config.StreamIDs() {
+		group := a.xtreamStreamGroup(id)
+		if !seen[group] {
+			seen[group] = true
+			categories = append(categories, xtreamCategoryOf(group))
+		}
+	}
+	return categories
+}
+
+func (a *ApiManagerCtx) xtreamLiveStreams(baseUrl string, categoryId string) []xtreamLiveStream {
+	streams := []xtreamLiveStream{}
+	for _, id := range a.config.StreamIDs() {
+		group := a.xtreamStreamGroup(id)
+		category := xtreamCategoryOf(group)
+		if categoryId != "" && categoryId != category.CategoryId {
+			continue
+		}
+
+		meta := a.config.StreamMeta(id)
+
+		name := meta.Name
+		if name == "" {
+			name = id
+		}
+
+		streamType := "live"
+		if meta.Radio {
+			streamType = "radio_streams"
+		}
+
+		streams = append(streams, xtreamLiveStream{
+			Num:          len(streams) + 1,
+			Name:         name,
+			StreamType:   streamType,
+			StreamId:     xtreamID(id),
+			StreamIcon:   a.streamLogoUrl(baseUrl, id, meta),
+			EpgChannelId: id,
+			Added:        "0",
+			CategoryId:   category.CategoryId,
+			group:        group,
+		})
+	}
+	return streams
+}
+
+// stream ID by numeric ID
+func (a *ApiManagerCtx) xtreamStream(streamId int) (string, bool) {
+	for _, id := range a.config.StreamIDs() {
+		if xtreamID(id) == streamId {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// media files, categorized by directory
+func (a *ApiManagerCtx) xtreamVods() []xtreamVod {
+	vods, _ := a.xtreamVodIndex()
+	return vods
+}
+
+func (a *ApiManagerCtx) xtreamVod(vodId int) (xtreamVod, bool) {
+	_, index := a.xtreamVodIndex()
+	vod, ok := index[vodId]
+	return vod, ok
+}
+
+// media files and their index by numeric ID, rebuilt as often as media files are listed
+func (a *ApiManagerCtx) xtreamVodIndex() ([]xtreamVod, map[int]xtreamVod) {
+	if a.config.Vod.MediaDir == "" {
+		return nil, nil
+	}
+
+	xtreamVodsMu.Lock()
+	defer xtreamVodsMu.Unlock()
+
+	if xtreamVodsById != nil && time.Now().Before(xtreamVodsExpires) {
+		return xtreamVodsList, xtreamVodsById
+	}
+
+	files, err := a.vodFiles()
+	if err != nil {
+		log.Warn().Err(err).Str("module", "xtream").Msg("unable to list media files")
+	}
+
+	vods := []xtreamVod{}
+	byId := map[int]xtreamVod{}
+	for _, file := range files {
+		category := path.Dir(file)
+		if category == "." {
+			category = xtreamDefaultVodCategory
+		}
+
+		vod := xtreamVod{
+			id:       xtreamID(file),
+			path:     file,
+			name:     strings.TrimSuffix(path.Base(file), path.Ext(file)),
+			category: category,
+		}
+
+		vods = append(vods, vod)
+		byId[vod.id] = vod
+	}
+
+	xtreamVodsList, xtreamVodsById = vods, byId
+	xtreamVodsExpires = time.Now().Add(vodFilesExpiration)
+	return vods, byId
+}
+
+func xtreamJson(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(data)
+}
@@ -1,15 +1,28 @@
 package config
 
 import (
-	"encoding/xml"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 
+	"github.com/mitchellh/mapstructure"
 	"github.com/rs/zerolog/log"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+
+	"github.com/m1k1o/go-transcode/internal/sources"
 )
 
 type Root struct {
@@ -65,22 +78,158 @@ type VOD struct {
 	FFprobeBinary  string                  `mapstructure:"ffprobe-binary"`
 }
 
+type Thumbnails struct {
+	Interval     time.Duration `mapstructure:"interval"` // how long is thumbnail cached
+	Width        int           `mapstructure:"width"`
+	Refresh      bool          `mapstructure:"refresh"` // refresh all streams in background
+	FFmpegBinary string        `mapstructure:"ffmpeg-binary"`
+}
+
+type HlsProxyAuth struct {
+	Username string `mapstructure:"username"` // basic auth
+	Password string `mapstructure:"password"`
+	Token    string `mapstructure:"token"` // bearer token
+}
+
+type HlsProxyPrefetch struct {
+	Segments    int           `mapstructure:"segments"` // 0 disables prefetching
+	Concurrency int           `mapstructure:"concurrency"`
+	Bandwidth   int64         `mapstructure:"bandwidth"` // in kilobytes per second, 0 means unlimited
+	Idle        time.Duration `mapstructure:"idle"`
+}
+
+type HlsProxyTranscode struct {
+	Input    string   `mapstructure:"input"`    // upstream playlist relative to url, e.g. master.m3u8
+	Profiles []string `mapstructure:"profiles"` // hls profiles offered alongside upstream variants
+}
+
+type HlsProxyRewrite struct {
+	MaxBandwidth  int      `mapstructure:"max-bandwidth"`  // in bits per second
+	MaxResolution int      `mapstructure:"max-resolution"` // height, e.g. 1080
+	Order         string   `mapstructure:"order"`          // asc or desc by bandwidth
+	StripTags     []string `mapstructure:"strip-tags"`
+	DefaultAudio  string   `mapstructure:"default-audio"` // language
+	Inject        []string `mapstructure:"inject"`
+}
+
+type HlsProxyKeys struct {
+	Expiration time.Duration `mapstructure:"expiration"` // how long are upstream keys cached
+	Decrypt    bool          `mapstructure:"decrypt"`    // serve AES-128 segments in clear
+	Reencrypt  bool          `mapstructure:"reencrypt"`  // encrypt segments by our own key
+	Key        string        `mapstructure:"key"`        // our own key in hex, random if empty
+	Tokens     []string      `mapstructure:"tokens"`     // required to get our own key, if set
+}
+
+type HlsProxy struct {
+	Url        string            `mapstructure:"url"`
+	Mirrors    []string          `mapstructure:"mirrors"` // used in order, when url is unhealthy
+	Headers    map[string]string `mapstructure:"headers"`
+	Query      string            `mapstructure:"query"` // e.g. token=abc&Session=1
+	Auth       HlsProxyAuth      `mapstructure:"auth"`
+	Timeout    time.Duration     `mapstructure:"timeout"`
+	Retries    int               `mapstructure:"retries"`
+	RetryDelay time.Duration     `mapstructure:"retry-delay"`
+	Insecure   bool              `mapstructure:"insecure"` // skip TLS verification
+	ProxyUrl   string            `mapstructure:"proxy-url"`
+	Prefetch   HlsProxyPrefetch  `mapstructure:"prefetch"`
+	Transcode  HlsProxyTranscode `mapstructure:"transcode"`
+	Rewrite    HlsProxyRewrite   `mapstructure:"rewrite"`
+	Keys       HlsProxyKeys      `mapstructure:"keys"`
+}
+
+// upstream encoded in url, for ad-hoc links
+type HlsProxyDynamic struct {
+	Enabled    bool          `mapstructure:"enabled"`
+	AllowHosts []string      `mapstructure:"allow-hosts"` // e.g. example.com or *.example.com
+	AllowCidrs []string      `mapstructure:"allow-cidrs"` // e.g. 10.0.0.0/8, checked against resolved addresses
+	Secret     string        `mapstructure:"secret"`      // signs proxied urls
+	Tokens     []string      `mapstructure:"tokens"`      // required to sign urls
+	MaxSources int           `mapstructure:"max-sources"` // least recently used sources are removed
+	Timeout    time.Duration `mapstructure:"timeout"`
+	Retries    int           `mapstructure:"retries"`
+	Insecure   bool          `mapstructure:"insecure"` // skip TLS verification
+}
+
+type HlsProxyCache struct {
+	Type    string `mapstructure:"type"`     // memory or disk
+	MaxSize int    `mapstructure:"max-size"` // in megabytes, 0 means unlimited
+	Dir     string `mapstructure:"dir"`      // for disk cache
+
+	RangeLimit int `mapstructure:"range-limit"` // in megabytes, larger resources are not cached whole for range requests
+}
+
+type Enigma2Bouquet struct {
+	Name      string `mapstructure:"name"`
+	Reference string `mapstructure:"reference"` // used instead of name, if set
+	Prefix    string `mapstructure:"prefix"`    // prepended to stream IDs, e.g. radio_
+}
+
 type Enigma2 struct {
-	WebifUrl  string `mapstructure:"webif-url"`
-	StreamUrl string `mapstructure:"stream-url"`
-	Bouquet   string
-	Reference string
+	WebifUrl  string           `mapstructure:"webif-url"`
+	StreamUrl string           `mapstructure:"stream-url"`
+	Bouquet   string           `mapstructure:"bouquet"`   // single bouquet, when bouquets are not set
+	Reference string           `mapstructure:"reference"` // single bouquet, when bouquets are not set
+	Bouquets  []Enigma2Bouquet `mapstructure:"bouquets"`
+	Refresh   time.Duration    `mapstructure:"refresh"`    // how often are services re-synced, 0 disables
+	Tuners    int              `mapstructure:"tuners"`     // number of tuners, 0 means unlimited
+	TunerWait time.Duration    `mapstructure:"tuner-wait"` // how long can session wait for free tuner
+	Picons    bool             `mapstructure:"picons"`     // get picons from receiver
+	PiconDir  string           `mapstructure:"picon-dir"`  // local picons, preferred over receiver
+}
+
+type EPG struct {
+	Sources []string      `mapstructure:"sources"` // XMLTV local files or http urls
+	Enigma2 bool          `mapstructure:"enigma2"` // get EPG of imported Enigma2 services
+	Refresh time.Duration `mapstructure:"refresh"`
 }
 
-type ServiceList struct {
-	XMLName     xml.Name  `xml:"e2servicelist"`
-	ServiceList []Service `xml:"e2service"`
+type M3U struct {
+	Sources       []string      `mapstructure:"sources"`        // local files or http urls
+	Refresh       time.Duration `mapstructure:"refresh"`        // how often are sources reloaded, 0 disables
+	IncludeGroups []string      `mapstructure:"include-groups"` // only these groups are imported, if set
+	ExcludeGroups []string      `mapstructure:"exclude-groups"`
 }
 
-type Service struct {
-	XMLName   xml.Name `xml:"e2service"`
-	Name      string   `xml:"e2servicename"`
-	Reference string   `xml:"e2servicereference"`
+// emulated HDHomeRun tuner for Plex, Jellyfin and Emby DVR
+type HDHomeRun struct {
+	Enabled      bool   `mapstructure:"enabled"`
+	Profile      string `mapstructure:"profile"`       // http profile used for lineup urls
+	DeviceID     string `mapstructure:"device-id"`     // 8 hex digits, generated if empty
+	FriendlyName string `mapstructure:"friendly-name"` // shown in DVR setup
+	Tuners       int    `mapstructure:"tuners"`        // defaults to enigma2 tuners, or 4
+	SSDP         bool   `mapstructure:"ssdp"`          // answer discovery on local network
+	BaseUrl      string `mapstructure:"base-url"`      // advertised by SSDP, e.g. http://192.168.1.2:8080
+}
+
+// Xtream Codes compatible API for IPTV apps
+type Xtream struct {
+	Enabled    bool              `mapstructure:"enabled"`
+	Users      map[string]string `mapstructure:"users"`       // username -> password
+	Profile    string            `mapstructure:"profile"`     // http profile for live .ts streams
+	HlsProfile string            `mapstructure:"hls-profile"` // hls profile for live .m3u8 streams, defaults to profile
+}
+
+// generic stream source
+type Source struct {
+	Type    string        `mapstructure:"type"` // tvheadend or hdhomerun
+	Url     string        `mapstructure:"url"`
+	Prefix  string        `mapstructure:"prefix"`  // prepended to stream IDs
+	Refresh time.Duration `mapstructure:"refresh"` // how often are streams reloaded, 0 disables
+	Profile string        `mapstructure:"profile"` // tvheadend streaming profile
+}
+
+type StreamMeta struct {
+	Name   string // human readable service name
+	Radio  bool   // audio only service
+	Source string // where was stream imported from, empty for configured streams
+	TvgId  string // EPG channel id
+	Group  string
+	Logo   string // url
+
+	Reference   string // Enigma2 service reference
+	Bouquet     string // Enigma2 bouquet reference
+	BouquetName string
+	Provider    string
 }
 
 type Server struct {
@@ -91,16 +240,41 @@ type Server struct {
 	Proxy  bool
 	CORS   bool
 
-	BaseDir  string            `yaml:"basedir,omitempty"`
-	Streams  map[string]string `yaml:"streams"`
-	Profiles string            `yaml:"profiles,omitempty"`
+	BaseDir     string                `yaml:"basedir,omitempty"`
+	Streams     map[string]string     `yaml:"streams"`
+	StreamsMeta map[string]StreamMeta `yaml:"-"`
+	Profiles    string                `yaml:"profiles,omitempty"`
 
 	Enigma2 Enigma2
+	M3U     M3U
+	EPG     EPG
+	Sources map[string]Source
+
+	HDHomeRun HDHomeRun
+	Xtream    Xtream
 
-	Vod      VOD
-	HlsProxy map[string]string
+	Thumbnails Thumbnails
+
+	Vod             VOD
+	HlsProxy        map[string]HlsProxy
+	DashProxy       map[string]HlsProxy // same upstream options as hls proxy
+	HlsProxyDynamic HlsProxyDynamic
+	HlsProxyCache   HlsProxyCache
 }
 
+// guards streams, as they can be refreshed at runtime
+var streamsMu sync.RWMutex
+
+// temporary directories by their prefix, reused when config is reloaded
+var tempDirs = map[string]string{}
+var tempDirsMu sync.Mutex
+
+// all configured stream sources
+var streamSources []sources.Source
+
+// sources whose streams were successfully loaded
+var streamsLoaded = map[string]bool{}
+
 func (Server) Init(cmd *cobra.Command) error {
 	cmd.PersistentFlags().String("bind", "127.0.0.1:8080", "address/port/socket to serve neko")
 	if err := viper.BindPFlag("bind", cmd.PersistentFlags().Lookup("bind")); err != nil {
@@ -168,7 +342,13 @@ func (s *Server) Set() {
 		// TODO: issue #5
 		s.Profiles = fmt.Sprintf("%s/profiles", s.BaseDir)
 	}
-	s.Streams = viper.GetStringMapString("streams")
+
+	// streams are built locally and swapped at once, as config can be reloaded at runtime
+	streams := &streamsCtx{
+		streams: viper.GetStringMapString("streams"),
+		meta:    map[string]StreamMeta{},
+		loaded:  map[string]bool{},
+	}
 
 	//
 	// VOD
@@ -181,7 +361,7 @@ func (s *Server) Set() {
 
 	if s.Vod.TranscodeDir == "" {
 		var err error
-		s.Vod.TranscodeDir, err = os.MkdirTemp(os.TempDir(), "go-transcode-vod")
+		s.Vod.TranscodeDir, err = tempDir("go-transcode-vod")
 		if err != nil {
 			panic(err)
 		}
@@ -211,10 +391,80 @@ func (s *Server) Set() {
 		s.Vod.FFprobeBinary = "ffprobe"
 	}
 
+	//
+	// Thumbnails
+	//
+	if err := viper.UnmarshalKey("thumbnails", &s.Thumbnails); err != nil {
+		panic(err)
+	}
+
+	// defaults
+
+	if s.Thumbnails.Interval == 0 {
+		s.Thumbnails.Interval = 60 * time.Second
+	}
+
+	if s.Thumbnails.FFmpegBinary == "" {
+		s.Thumbnails.FFmpegBinary = "ffmpeg"
+	}
+
 	//
 	// HLS PROXY
 	//
-	s.HlsProxy = viper.GetStringMapString("hls-proxy")
+	s.HlsProxy = unmarshalProxies("hls-proxy")
+	s.DashProxy = unmarshalProxies("dash-proxy")
+
+	if err := viper.UnmarshalKey("hls-proxy-dynamic", &s.HlsProxyDynamic); err != nil {
+		panic(err)
+	}
+
+	if err := viper.UnmarshalKey("hls-proxy-cache", &s.HlsProxyCache); err != nil {
+		panic(err)
+	}
+
+	// defaults
+
+	if s.HlsProxyDynamic.Enabled {
+		// do not become open proxy
+		if len(s.HlsProxyDynamic.AllowHosts) == 0 && len(s.HlsProxyDynamic.AllowCidrs) == 0 {
+			panic("hls proxy dynamic requires allow-hosts or allow-cidrs")
+		}
+
+		for _, cidr := range s.HlsProxyDynamic.AllowCidrs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				panic(fmt.Sprintf("hls proxy dynamic has invalid cidr %s: %v", cidr, err))
+			}
+		}
+
+		// proxied urls are not checked for tokens, only signed ones can be trusted
+		if s.HlsProxyDynamic.Secret == "" || len(s.HlsProxyDynamic.Tokens) == 0 {
+			panic("hls proxy dynamic requires secret and tokens")
+		}
+
+		if s.HlsProxyDynamic.MaxSources <= 0 {
+			s.HlsProxyDynamic.MaxSources = 100
+		}
+
+		if !viper.IsSet("hls-proxy-dynamic.retries") {
+			s.HlsProxyDynamic.Retries = 2
+		}
+	}
+
+	if s.HlsProxyCache.Type == "" {
+		s.HlsProxyCache.Type = "memory"
+	}
+
+	if s.HlsProxyCache.Type != "memory" && s.HlsProxyCache.Type != "disk" {
+		panic("hls proxy cache type must be memory or disk")
+	}
+
+	if s.HlsProxyCache.Type == "disk" && s.HlsProxyCache.Dir == "" {
+		var err error
+		s.HlsProxyCache.Dir, err = tempDir("go-transcode-hlsproxy")
+		if err != nil {
+			panic(err)
+		}
+	}
 
 	//
 	// Enigma2
@@ -223,18 +473,330 @@ func (s *Server) Set() {
 		panic(err)
 	}
 
+	// defaults
+
+	if len(s.Enigma2.Bouquets) == 0 {
+		s.Enigma2.Bouquets = []Enigma2Bouquet{{
+			Name:      s.Enigma2.Bouquet,
+			Reference: s.Enigma2.Reference,
+		}}
+	}
+
+	if !viper.IsSet("enigma2.refresh") {
+		s.Enigma2.Refresh = time.Hour
+	}
+
 	if s.Enigma2.WebifUrl != "" {
-		enigma2Streams, err := parseEnigma2Config(s.Enigma2)
+		source := sources.NewEnigma2(sources.Enigma2Config{
+			WebifUrl:  s.Enigma2.WebifUrl,
+			StreamUrl: s.Enigma2.StreamUrl,
+			Bouquets:  enigma2Bouquets(s.Enigma2.Bouquets),
+			Refresh:   s.Enigma2.Refresh,
+		})
+		streams.sources = append(streams.sources, source)
+
+		// server starts even if receiver is offline, sync is retried later
+		if err := streams.load(context.Background(), source); err != nil {
+			log.Err(err).Msg("unable to load streams from Enigma2")
+		}
+	}
+
+	//
+	// M3U
+	//
+	if err := viper.UnmarshalKey("m3u", &s.M3U); err != nil {
+		panic(err)
+	}
+
+	if len(s.M3U.Sources) > 0 {
+		source := sources.NewM3U(sources.M3UConfig{
+			Sources:       s.M3U.Sources,
+			Refresh:       s.M3U.Refresh,
+			IncludeGroups: s.M3U.IncludeGroups,
+			ExcludeGroups: s.M3U.ExcludeGroups,
+		}, s.AbsPath)
+		streams.sources = append(streams.sources, source)
+
+		// server starts even if playlist is unavailable, loading is retried later
+		if err := streams.load(context.Background(), source); err != nil {
+			log.Err(err).Msg("unable to load streams from M3U")
+		}
+	}
+
+	//
+	// Sources
+	//
+	if err := viper.UnmarshalKey("sources", &s.Sources); err != nil {
+		panic(err)
+	}
+
+	// sorted, so that stream ID collisions are resolved the same way every time
+	names := make([]string, 0, len(s.Sources))
+	for name := range s.Sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		conf := s.Sources[name]
+
+		// streams are replaced by source name
+		if name == "enigma2" || name == "m3u" {
+			panic(fmt.Sprintf("source name %s is reserved", name))
+		}
+
+		if conf.Url == "" {
+			panic(fmt.Sprintf("source %s requires url", name))
+		}
+
+		source, err := sources.New(conf.Type, name, sources.Config{
+			Url:     conf.Url,
+			Prefix:  conf.Prefix,
+			Refresh: conf.Refresh,
+			Profile: conf.Profile,
+		})
 		if err != nil {
-			panic(err)
+			panic(fmt.Sprintf("source %s: %v", name, err))
 		}
+		streams.sources = append(streams.sources, source)
 
-		for k, v := range enigma2Streams {
-			s.Streams[k] = v
+		// server starts even if source is offline, loading is retried later
+		if err := streams.load(context.Background(), source); err != nil {
+			log.Err(err).Str("source", name).Msg("unable to load streams from source")
 		}
+	}
+
+	streamsMu.Lock()
+	s.Streams, s.StreamsMeta = streams.streams, streams.meta
+	streamSources, streamsLoaded = streams.sources, streams.loaded
+	streamsMu.Unlock()
 
-		log.Info().Msgf("loaded %d streams from Enigma2", len(enigma2Streams))
+	//
+	// EPG
+	//
+	if err := viper.UnmarshalKey("epg", &s.EPG); err != nil {
+		panic(err)
 	}
+
+	// defaults
+
+	if s.EPG.Refresh <= 0 {
+		s.EPG.Refresh = 6 * time.Hour
+	}
+
+	//
+	// HDHomeRun
+	//
+	if err := viper.UnmarshalKey("hdhomerun", &s.HDHomeRun); err != nil {
+		panic(err)
+	}
+
+	// defaults
+
+	if s.HDHomeRun.Enabled {
+		if s.HDHomeRun.Profile == "" {
+			panic("hdhomerun requires profile")
+		}
+
+		if s.HDHomeRun.DeviceID == "" {
+			// stable across restarts
+			hostname, _ := os.Hostname()
+			s.HDHomeRun.DeviceID = hdhomerunDeviceID(hostname + s.Bind)
+		} else if id, err := strconv.ParseUint(s.HDHomeRun.DeviceID, 16, 32); err != nil || len(s.HDHomeRun.DeviceID) != 8 {
+			panic("hdhomerun device-id must be 8 hex digits")
+		} else {
+			s.HDHomeRun.DeviceID = fmt.Sprintf("%08X", id)
+		}
+
+		if s.HDHomeRun.FriendlyName == "" {
+			s.HDHomeRun.FriendlyName = "go-transcode"
+		}
+
+		// clients should not start more sessions than receiver can handle
+		if s.HDHomeRun.Tuners <= 0 {
+			s.HDHomeRun.Tuners = s.Enigma2.Tuners
+		}
+		if s.HDHomeRun.Tuners <= 0 {
+			s.HDHomeRun.Tuners = 4
+		}
+
+		if !viper.IsSet("hdhomerun.ssdp") {
+			s.HDHomeRun.SSDP = true
+		}
+	}
+
+	//
+	// Xtream
+	//
+	if err := viper.UnmarshalKey("xtream", &s.Xtream); err != nil {
+		panic(err)
+	}
+
+	// defaults
+
+	if s.Xtream.Enabled {
+		if len(s.Xtream.Users) == 0 {
+			panic("xtream requires at least one user")
+		}
+
+		if s.Xtream.Profile == "" {
+			panic("xtream requires profile")
+		}
+
+		if s.Xtream.HlsProfile == "" {
+			s.Xtream.HlsProfile = s.Xtream.Profile
+		}
+	}
+}
+
+// Stream returns url of stream, streams can be refreshed at runtime.
+func (s *Server) Stream(id string) (string, bool) {
+	streamsMu.RLock()
+	defer streamsMu.RUnlock()
+
+	url, ok := s.Streams[id]
+	return url, ok
+}
+
+// StreamMeta returns metadata of stream, if known.
+func (s *Server) StreamMeta(id string) StreamMeta {
+	streamsMu.RLock()
+	defer streamsMu.RUnlock()
+
+	return s.StreamsMeta[id]
+}
+
+// StreamIDs returns sorted IDs of all streams.
+func (s *Server) StreamIDs() []string {
+	streamsMu.RLock()
+	defer streamsMu.RUnlock()
+
+	ids := make([]string, 0, len(s.Streams))
+	for id := range s.Streams {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+	return ids
+}
+
+// StreamsLoaded returns whether streams from source were successfully loaded.
+func (s *Server) StreamsLoaded(source string) bool {
+	streamsMu.RLock()
+	defer streamsMu.RUnlock()
+
+	return streamsLoaded[source]
+}
+
+// streams with their sources, guarded by streamsMu when in use
+type streamsCtx struct {
+	streams map[string]string
+	meta    map[string]StreamMeta
+	sources []sources.Source
+	loaded  map[string]bool // sources whose streams were successfully loaded
+}
+
+// imports streams from source, replacing previously imported ones
+func (c *streamsCtx) load(ctx context.Context, source sources.Source) error {
+	streams, meta, err := listSource(ctx, source)
+	if err != nil {
+		return err
+	}
+
+	c.replace(source.Name(), streams, meta)
+
+	log.Info().Str("source", source.Name()).Msgf("loaded %d streams", len(streams))
+	return nil
+}
+
+// replace all streams imported from source, other streams are kept
+func (c *streamsCtx) replace(source string, streams map[string]string, meta map[string]StreamMeta) {
+	for id := range c.streams {
+		if c.meta[id].Source == source {
+			delete(c.streams, id)
+			delete(c.meta, id)
+		}
+	}
+
+	for id, url := range streams {
+		// do not override streams from other sources
+		if _, ok := c.streams[id]; ok {
+			log.Warn().Str("id", id).Str("source", source).Msg("stream already exists, skipping")
+			continue
+		}
+
+		c.streams[id] = url
+		c.meta[id] = meta[id]
+	}
+
+	c.loaded[source] = true
+}
+
+// StreamSources returns all configured stream sources.
+func (s *Server) StreamSources() []sources.Source {
+	streamsMu.RLock()
+	defer streamsMu.RUnlock()
+
+	return streamSources
+}
+
+// LoadSource imports streams from source, replacing previously imported ones.
+func (s *Server) LoadSource(ctx context.Context, source sources.Source) error {
+	streams, meta, err := listSource(ctx, source)
+	if err != nil {
+		return err
+	}
+
+	streamsMu.Lock()
+	defer streamsMu.Unlock()
+
+	// source could have been replaced by config reload, while it was being listed
+	configured := false
+	for _, other := range streamSources {
+		configured = configured || other == source
+	}
+	if !configured {
+		return fmt.Errorf("source %s is not configured anymore", source.Name())
+	}
+
+	current := &streamsCtx{s.Streams, s.StreamsMeta, streamSources, streamsLoaded}
+	current.replace(source.Name(), streams, meta)
+
+	log.Info().Str("source", source.Name()).Msgf("loaded %d streams", len(streams))
+	return nil
+}
+
+// gets streams offered by source
+func listSource(ctx context.Context, source sources.Source) (map[string]string, map[string]StreamMeta, error) {
+	list, err := source.List(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	streams := map[string]string{}
+	meta := map[string]StreamMeta{}
+	for _, stream := range list {
+		if _, ok := streams[stream.ID]; ok {
+			log.Warn().Str("id", stream.ID).Str("source", source.Name()).Msg("duplicate stream ID, skipping")
+			continue
+		}
+
+		streams[stream.ID] = stream.Url
+		meta[stream.ID] = StreamMeta{
+			Name:        stream.Name,
+			Radio:       stream.Radio,
+			Source:      source.Name(),
+			TvgId:       stream.TvgId,
+			Group:       stream.Group,
+			Logo:        stream.Logo,
+			Reference:   stream.Reference,
+			Bouquet:     stream.Bouquet,
+			BouquetName: stream.BouquetName,
+			Provider:    stream.Provider,
+		}
+	}
+
+	return streams, meta, nil
 }
 
 func (s *Server) AbsPath(elem ...string) string {
@@ -242,3 +804,102 @@ func (s *Server) AbsPath(elem ...string) string {
 	elem = append([]string{s.BaseDir}, elem...)
 	return path.Join(elem...)
 }
+
+// temporary directory created once, config can be set multiple times
+func tempDir(prefix string) (string, error) {
+	tempDirsMu.Lock()
+	defer tempDirsMu.Unlock()
+
+	if dir, ok := tempDirs[prefix]; ok {
+		return dir, nil
+	}
+
+	dir, err := os.MkdirTemp(os.TempDir(), prefix)
+	if err != nil {
+		return "", err
+	}
+
+	tempDirs[prefix] = dir
+	return dir, nil
+}
+
+func enigma2Bouquets(bouquets []Enigma2Bouquet) []sources.Enigma2Bouquet {
+	out := []sources.Enigma2Bouquet{}
+	for _, bouquet := range bouquets {
+		out = append(out, sources.Enigma2Bouquet{
+			Name:      bouquet.Name,
+			Reference: bouquet.Reference,
+			Prefix:    bouquet.Prefix,
+		})
+	}
+	return out
+}
+
+// checksum nibble is used by HDHomeRun clients to validate device IDs
+var hdhomerunChecksumTable = [16]uint32{0xA, 0x5, 0xF, 0x6, 0x7, 0xC, 0x1, 0xB, 0x9, 0x2, 0x8, 0xD, 0x4, 0x3, 0xE, 0x0}
+
+// valid HDHomeRun device ID derived from seed
+func hdhomerunDeviceID(seed string) string {
+	sum := sha256.Sum256([]byte(seed))
+	id := binary.BigEndian.Uint32(sum[:4]) &^ 0xF
+
+	var checksum uint32
+	for i := 7; i >= 1; i-- {
+		nibble := (id >> (4 * i)) & 0xF
+		if i%2 == 1 {
+			nibble = hdhomerunChecksumTable[nibble]
+		}
+		checksum ^= nibble
+	}
+
+	return fmt.Sprintf("%08X", id|checksum)
+}
+
+// proxies can be defined in simple form <id>: <url> or as structure
+func unmarshalProxies(key string) map[string]HlsProxy {
+	proxies := map[string]HlsProxy{}
+	if err := viper.UnmarshalKey(key, &proxies, viper.DecodeHook(
+		mapstructure.ComposeDecodeHookFunc(
+			// allow simple form <id>: <url>
+			func(f reflect.Type, t reflect.Type, data interface{}) (interface{}, error) {
+				if f.Kind() == reflect.String && t == reflect.TypeOf(HlsProxy{}) {
+					return HlsProxy{Url: data.(string)}, nil
+				}
+				return data, nil
+			},
+			mapstructure.StringToTimeDurationHookFunc(),
+		),
+	)); err != nil {
+		panic(err)
+	}
+
+	for id, proxy := range proxies {
+		if proxy.Url == "" {
+			panic(fmt.Sprintf("%s %s is missing url", key, id))
+		}
+
+		if _, err := url.ParseQuery(proxy.Query); err != nil {
+			panic(fmt.Sprintf("%s %s has invalid query: %v", key, id, err))
+		}
+
+		if order := proxy.Rewrite.Order; order != "" && order != "asc" && order != "desc" {
+			panic(fmt.Sprintf("%s %s rewrite order must be asc or desc", key, id))
+		}
+
+		if k, err := hex.DecodeString(proxy.Keys.Key); err != nil || (len(k) != 0 && len(k) != 16) {
+			panic(fmt.Sprintf("%s %s key must be 16 bytes in hex", key, id))
+		}
+
+		if len(proxy.Transcode.Profiles) > 0 && proxy.Transcode.Input == "" {
+			panic(fmt.Sprintf("%s %s is missing transcode input", key, id))
+		}
+
+		// retry transient errors by default
+		if !viper.IsSet(fmt.Sprintf("%s.%s.retries", key, id)) {
+			proxy.Retries = 2
+			proxies[id] = proxy
+		}
+	}
+
+	return proxies
+}
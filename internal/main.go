@@ -50,7 +50,7 @@ func (main *Main) Start() {
 		main.logger.Info().Msgf("mounted debug pprof endpoint")
 	}
 
-	main.logger.Info().Msgf("serving streams from basedir %s: %s", config.BaseDir, config.Streams)
+	main.logger.Info().Msgf("serving streams from basedir %s: %s", config.BaseDir, config.StreamIDs())
 }
 
 func (main *Main) Shutdown() {
@@ -80,4 +80,9 @@ func (main *Main) ServeCommand(cmd *cobra.Command, args []string) {
 func (main *Main) ConfigReload() {
 	main.RootConfig.Set()
 	main.ServerConfig.Set()
+
+	// sources were created again
+	if main.apiManager != nil {
+		main.apiManager.SourcesRestart()
+	}
 }
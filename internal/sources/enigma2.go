@@ -0,0 +1,341 @@
+package sources
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// bouquets lists, bouquet names are looked up in both
+const enigma2TvBouquets = `1:7:1:0:0:0:0:0:0:0:FROM BOUQUET "bouquets.tv" ORDER BY bouquet`
+const enigma2RadioBouquets = `1:7:2:0:0:0:0:0:0:0:FROM BOUQUET "bouquets.radio" ORDER BY bouquet`
+
+type Enigma2Bouquet struct {
+	Name      string
+	Reference string // used instead of name, if set
+	Prefix    string // prepended to stream IDs
+}
+
+type Enigma2Config struct {
+	WebifUrl  string
+	StreamUrl string // defaults to webif host with port 8001
+	Bouquets  []Enigma2Bouquet
+	Refresh   time.Duration
+}
+
+type Enigma2Ctx struct {
+	config Enigma2Config
+}
+
+// NewEnigma2 creates source importing services of Enigma2 bouquets.
+func NewEnigma2(config Enigma2Config) *Enigma2Ctx {
+	return &Enigma2Ctx{config}
+}
+
+func (e *Enigma2Ctx) Name() string {
+	return "enigma2"
+}
+
+func (e *Enigma2Ctx) Refresh() time.Duration {
+	return e.config.Refresh
+}
+
+func (e *Enigma2Ctx) List(ctx context.Context) ([]Stream, error) {
+	conf := e.config
+
+	// parse webif url
+	webifUrl, err := url.Parse(conf.WebifUrl)
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing enigma2 webif url: %w", err)
+	}
+
+	// if there is no streaming url, create it from webif url
+	if conf.StreamUrl == "" {
+		// create streaming url
+		conf.StreamUrl = webifUrl.Scheme + "://"
+		// add password and username if set
+		if webifUrl.User != nil {
+			conf.StreamUrl += webifUrl.User.String() + "@"
+		}
+		// add host and port
+		conf.StreamUrl += webifUrl.Hostname() + ":8001/"
+	}
+
+	// parse streaming url
+	streamUrl, err := url.Parse(conf.StreamUrl)
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing enigma2 streaming url: %w", err)
+	}
+
+	apiUrl := *webifUrl
+	apiUrl.Path = path.Join(apiUrl.Path, "/web/getservices")
+
+	// bouquets lists are loaded only when needed
+	var bouquets []enigma2Service
+
+	streams := []Stream{}
+	ids := map[string]string{} // stream ID -> service reference
+	for _, bouquet := range conf.Bouquets {
+		// use default bouquet if not set
+		if bouquet.Name == "" && bouquet.Reference == "" {
+			bouquet.Name = "Favourites (TV)"
+		}
+
+		// find reference by bouquet name
+		if bouquet.Reference == "" {
+			if bouquets == nil {
+				for _, list := range []string{enigma2TvBouquets, enigma2RadioBouquets} {
+					services, err := enigma2Services(ctx, enigma2ApiUrl(apiUrl, list))
+					if err != nil {
+						return nil, fmt.Errorf("error while getting enigma2 bouquets: %w", err)
+					}
+					bouquets = append(bouquets, services...)
+				}
+			}
+
+			for _, service := range bouquets {
+				if service.Name == bouquet.Name {
+					bouquet.Reference = service.Reference
+					break
+				}
+			}
+
+			if bouquet.Reference == "" {
+				return nil, fmt.Errorf("could not find bouquet %s", bouquet.Name)
+			}
+		}
+
+		// get services from webif
+		services, err := enigma2Services(ctx, enigma2ApiUrl(apiUrl, bouquet.Reference))
+		if err != nil {
+			return nil, fmt.Errorf("error while getting enigma2 services: %w", err)
+		}
+
+		// providers are optional, not every webif offers them
+		enigma2Providers(ctx, webifUrl, bouquet.Reference, services)
+
+		for _, service := range services {
+			// skip markers and separators
+			if enigma2IsMarker(service.Reference) {
+				continue
+			}
+
+			chUrl := *streamUrl
+			chUrl.Path = path.Join(chUrl.Path, service.Reference)
+
+			name := bouquet.Prefix + enigma2ChannelName(service.Name)
+
+			// same service can be in multiple bouquets
+			if reference, ok := ids[name]; ok && reference == service.Reference {
+				continue
+			}
+
+			// different services with same name get unique IDs
+			id := name
+			for i := 2; ; i++ {
+				if _, ok := ids[id]; !ok {
+					break
+				}
+				id = name + "_" + strconv.Itoa(i)
+			}
+
+			if id != name {
+				log.Warn().Str("name", service.Name).Str("id", id).Msg("enigma2 stream ID collision, using unique ID")
+			}
+
+			ids[id] = service.Reference
+			streams = append(streams, Stream{
+				ID:          id,
+				Url:         chUrl.String(),
+				Name:        service.Name,
+				Radio:       enigma2IsRadio(service.Reference),
+				Reference:   service.Reference,
+				Bouquet:     bouquet.Reference,
+				BouquetName: bouquet.Name,
+				Provider:    service.Provider,
+			})
+		}
+	}
+
+	return streams, nil
+}
+
+func enigma2ApiUrl(apiUrl url.URL, reference string) string {
+	q := apiUrl.Query()
+	q.Set("sRef", reference)
+	apiUrl.RawQuery = q.Encode()
+	return apiUrl.String()
+}
+
+type enigma2ServiceList struct {
+	XMLName  xml.Name         `xml:"e2servicelist"`
+	Services []enigma2Service `xml:"e2service"`
+}
+
+type enigma2Service struct {
+	XMLName   xml.Name `xml:"e2service"`
+	Name      string   `xml:"e2servicename"`
+	Reference string   `xml:"e2servicereference"`
+	Provider  string   `xml:"-"` // from OpenWebif json api, if available
+}
+
+// get services from webif
+func enigma2Services(ctx context.Context, url string) ([]enigma2Service, error) {
+	body, err := httpGet(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("read body: %w", err)
+	}
+
+	var obj enigma2ServiceList
+	err = xml.Unmarshal(data, &obj)
+	if err != nil {
+		return nil, err
+	}
+
+	return obj.Services, nil
+}
+
+// service reference has format 1:0:<type>:<sid>:<tsid>:<onid>:<namespace>:0:0:0:
+// where type 2 is radio and 10 is advanced codec radio
+func enigma2IsRadio(reference string) bool {
+	parts := strings.Split(reference, ":")
+	if len(parts) < 3 {
+		return false
+	}
+
+	serviceType, err := strconv.ParseInt(parts[2], 16, 64)
+	if err != nil {
+		return false
+	}
+
+	return serviceType == 0x2 || serviceType == 0xA
+}
+
+// fill in service providers from OpenWebif json api
+func enigma2Providers(ctx context.Context, webifUrl *url.URL, bouquetReference string, services []enigma2Service) {
+	apiUrl := *webifUrl
+	apiUrl.Path = path.Join(apiUrl.Path, "/api/getservices")
+	apiUrl.RawQuery = url.Values{"sRef": {bouquetReference}, "provider": {"1"}}.Encode()
+
+	body, err := httpGet(ctx, apiUrl.String())
+	if err != nil {
+		log.Debug().Err(err).Msg("unable to get enigma2 providers")
+		return
+	}
+	defer body.Close()
+
+	var obj struct {
+		Services []struct {
+			Reference string `json:"servicereference"`
+			Provider  string `json:"provider"`
+		} `json:"services"`
+	}
+
+	if err := json.NewDecoder(body).Decode(&obj); err != nil {
+		log.Debug().Err(err).Msg("unable to get enigma2 providers")
+		return
+	}
+
+	providers := map[string]string{}
+	for _, service := range obj.Services {
+		providers[service.Reference] = service.Provider
+	}
+
+	for i := range services {
+		services[i].Provider = providers[services[i].Reference]
+	}
+}
+
+// Enigma2Transponder returns transponder of DVB service, derived from its reference
+// 1:0:<type>:<sid>:<tsid>:<onid>:<namespace>:0:0:0: as <tsid>:<onid>:<namespace>.
+// Services on the same transponder can be received by single tuner.
+func Enigma2Transponder(reference string) string {
+	parts := strings.Split(reference, ":")
+	if len(parts) < 7 || parts[0] != "1" {
+		return ""
+	}
+
+	// streams from url do not need tuner
+	if len(parts) > 10 && parts[10] != "" {
+		return ""
+	}
+
+	return strings.ToUpper(strings.Join(parts[4:7], ":"))
+}
+
+// Enigma2PiconName returns picon file name without extension, derived from
+// service reference, e.g. 1:0:19:283D:3FB:1:C00000:0:0:0: becomes 1_0_19_283D_3FB_1_C00000_0_0_0
+func Enigma2PiconName(reference string) string {
+	parts := strings.Split(strings.TrimSuffix(reference, ":"), ":")
+
+	// strip url and name of IPTV services
+	if len(parts) > 10 {
+		parts = parts[:10]
+	}
+
+	// IPTV service types share picons with DVB services
+	switch parts[0] {
+	case "4097", "5001", "5002", "5003":
+		parts[0] = "1"
+	}
+
+	// flags are not part of picon name
+	if len(parts) > 1 {
+		parts[1] = "0"
+	}
+
+	return strings.ToUpper(strings.Join(parts, "_"))
+}
+
+// Enigma2PiconSnpName returns picon file name based on service name, e.g. "ProSieben HD" becomes prosiebenhd
+func Enigma2PiconSnpName(name string) string {
+	name = strings.ToLower(name)
+	name = strings.ReplaceAll(name, "&", "and")
+	name = strings.ReplaceAll(name, "+", "plus")
+	name = strings.ReplaceAll(name, "*", "star")
+
+	var b strings.Builder
+	for _, c := range name {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// service reference flags (decimal) 64 is marker, e.g. 1:64:1:0:0:0:0:0:0:0::Movies
+func enigma2IsMarker(reference string) bool {
+	parts := strings.Split(reference, ":")
+	if len(parts) < 2 {
+		return false
+	}
+
+	flags, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return false
+	}
+
+	return flags&64 != 0
+}
+
+func enigma2ChannelName(name string) string {
+	name = strings.ToLower(name)
+	name = strings.ReplaceAll(name, " ", "_")
+	name = strings.ReplaceAll(name, "-", "_")
+	return name
+}
@@ -0,0 +1,112 @@
+package sources
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestEnigma2List(t *testing.T) {
+	const favourites = `1:7:1:0:0:0:0:0:0:0:FROM BOUQUET "userbouquet.favourites.tv" ORDER BY bouquet`
+
+	services := map[string]string{
+		enigma2TvBouquets: `<e2service>
+			<e2servicereference>` + favourites + `</e2servicereference>
+			<e2servicename>Favourites (TV)</e2servicename>
+		</e2service>`,
+		enigma2RadioBouquets: ``,
+		favourites: `<e2service>
+			<e2servicereference>1:0:19:283D:3FB:1:C00000:0:0:0:</e2servicereference>
+			<e2servicename>Das Erste HD</e2servicename>
+		</e2service>
+		<e2service>
+			<e2servicereference>1:64:1:0:0:0:0:0:0:0::News</e2servicereference>
+			<e2servicename>News</e2servicename>
+		</e2service>
+		<e2service>
+			<e2servicereference>1:0:2:6F37:431:A401:FFFF0000:0:0:0:</e2servicereference>
+			<e2servicename>Das Erste HD</e2servicename>
+		</e2service>`,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/web/getservices":
+			list, ok := services[r.URL.Query().Get("sRef")]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><e2servicelist>%s</e2servicelist>`, list)
+		case "/api/getservices":
+			fmt.Fprint(w, `{"services": [{"servicereference": "1:0:19:283D:3FB:1:C00000:0:0:0:", "provider": "ARD"}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	source := NewEnigma2(Enigma2Config{
+		WebifUrl:  server.URL,
+		StreamUrl: "http://receiver:8001/",
+		Bouquets:  []Enigma2Bouquet{{Name: "Favourites (TV)"}},
+	})
+
+	got, err := source.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Stream{
+		{
+			ID:          "das_erste_hd",
+			Url:         "http://receiver:8001/1:0:19:283D:3FB:1:C00000:0:0:0:",
+			Name:        "Das Erste HD",
+			Reference:   "1:0:19:283D:3FB:1:C00000:0:0:0:",
+			Bouquet:     favourites,
+			BouquetName: "Favourites (TV)",
+			Provider:    "ARD",
+		},
+		{
+			ID:          "das_erste_hd_2",
+			Url:         "http://receiver:8001/1:0:2:6F37:431:A401:FFFF0000:0:0:0:",
+			Name:        "Das Erste HD",
+			Radio:       true,
+			Reference:   "1:0:2:6F37:431:A401:FFFF0000:0:0:0:",
+			Bouquet:     favourites,
+			BouquetName: "Favourites (TV)",
+		},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %+v, want %+v", got, want)
+	}
+
+	// unknown bouquet
+	source = NewEnigma2(Enigma2Config{
+		WebifUrl: server.URL,
+		Bouquets: []Enigma2Bouquet{{Name: "Missing"}},
+	})
+
+	if _, err := source.List(context.Background()); err == nil {
+		t.Errorf("List() of unknown bouquet did not fail")
+	}
+}
+
+func TestEnigma2Transponder(t *testing.T) {
+	tests := map[string]string{
+		"1:0:19:283D:3FB:1:C00000:0:0:0:":                      "3FB:1:C00000",
+		"1:0:1:0:0:0:0:0:0:0:http%3a//example.com/stream:Name": "",
+		"4097:0:1:0:0:0:0:0:0:0:http%3a//example.com/stream":   "",
+		"1:0:19": "",
+	}
+
+	for reference, want := range tests {
+		if got := Enigma2Transponder(reference); got != want {
+			t.Errorf("Enigma2Transponder(%q) = %q, want %q", reference, got, want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package sources
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+)
+
+type HDHomeRunCtx struct {
+	name   string
+	config Config
+}
+
+// NewHDHomeRun creates source importing channels from HDHomeRun tuner lineup.
+func NewHDHomeRun(name string, config Config) *HDHomeRunCtx {
+	return &HDHomeRunCtx{name, config}
+}
+
+func (h *HDHomeRunCtx) Name() string {
+	return h.name
+}
+
+func (h *HDHomeRunCtx) Refresh() time.Duration {
+	return h.config.Refresh
+}
+
+type hdhomerunChannel struct {
+	GuideNumber string `json:"GuideNumber"`
+	GuideName   string `json:"GuideName"`
+	URL         string `json:"URL"`
+	DRM         int    `json:"DRM"` // 1 if encrypted
+}
+
+func (h *HDHomeRunCtx) List(ctx context.Context) ([]Stream, error) {
+	baseUrl, err := url.Parse(h.config.Url)
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing hdhomerun url: %w", err)
+	}
+
+	lineupUrl := *baseUrl
+	lineupUrl.Path = path.Join(lineupUrl.Path, "/lineup.json")
+
+	body, err := httpGet(ctx, lineupUrl.String())
+	if err != nil {
+		return nil, fmt.Errorf("error while getting hdhomerun lineup: %w", err)
+	}
+	defer body.Close()
+
+	var lineup []hdhomerunChannel
+	if err := json.NewDecoder(body).Decode(&lineup); err != nil {
+		return nil, fmt.Errorf("error while parsing hdhomerun lineup: %w", err)
+	}
+
+	streams := []Stream{}
+	ids := map[string]struct{}{}
+	for _, channel := range lineup {
+		// encrypted channels can not be transcoded
+		if channel.DRM != 0 || channel.URL == "" {
+			continue
+		}
+
+		name := StreamID(channel.GuideName)
+		if name == "" {
+			name = StreamID(channel.GuideNumber)
+		}
+		if name == "" {
+			continue
+		}
+		name = h.config.Prefix + name
+
+		// make duplicate names unique
+		id := name
+		for i := 2; ; i++ {
+			if _, ok := ids[id]; !ok {
+				break
+			}
+			id = name + "_" + strconv.Itoa(i)
+		}
+		ids[id] = struct{}{}
+
+		chName := channel.GuideName
+		if chName == "" {
+			chName = channel.GuideNumber
+		}
+
+		streams = append(streams, Stream{
+			ID:    id,
+			Url:   channel.URL,
+			Name:  chName,
+			TvgId: channel.GuideNumber,
+		})
+	}
+
+	return streams, nil
+}
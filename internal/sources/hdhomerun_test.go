@@ -0,0 +1,48 @@
+package sources
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestHDHomeRunList(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/lineup.json" {
+			http.NotFound(w, r)
+			return
+		}
+
+		fmt.Fprint(w, `[
+			{"GuideNumber": "2.1", "GuideName": "KCBS-HD", "URL": "http://192.168.1.10:5004/auto/v2.1"},
+			{"GuideNumber": "5.1", "GuideName": "Premium", "URL": "http://192.168.1.10:5004/auto/v5.1", "DRM": 1},
+			{"GuideNumber": "7.1", "GuideName": "KCBS-HD", "URL": "http://192.168.1.10:5004/auto/v7.1"}
+		]`)
+	}))
+	defer server.Close()
+
+	source := NewHDHomeRun("hdhr", Config{Url: server.URL})
+
+	got, err := source.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Stream{
+		{ID: "kcbs-hd", Url: "http://192.168.1.10:5004/auto/v2.1", Name: "KCBS-HD", TvgId: "2.1"},
+		{ID: "kcbs-hd_2", Url: "http://192.168.1.10:5004/auto/v7.1", Name: "KCBS-HD", TvgId: "7.1"},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %+v, want %+v", got, want)
+	}
+
+	// lineup not available
+	source = NewHDHomeRun("hdhr", Config{Url: server.URL + "/missing"})
+	if _, err := source.List(context.Background()); err == nil {
+		t.Errorf("List() of missing lineup did not fail")
+	}
+}
@@ -0,0 +1,224 @@
+package sources
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var m3uAttrRegex = regexp.MustCompile(`([A-Za-z0-9_-]+)="([^"]*)"`)
+
+type M3UConfig struct {
+	Sources       []string // local files or http urls
+	Refresh       time.Duration
+	IncludeGroups []string
+	ExcludeGroups []string
+}
+
+type M3UCtx struct {
+	config  M3UConfig
+	absPath func(elem ...string) string
+}
+
+// NewM3U creates source importing M3U playlists, local files are relative to base dir.
+func NewM3U(config M3UConfig, absPath func(elem ...string) string) *M3UCtx {
+	return &M3UCtx{config, absPath}
+}
+
+func (m *M3UCtx) Name() string {
+	return "m3u"
+}
+
+func (m *M3UCtx) Refresh() time.Duration {
+	return m.config.Refresh
+}
+
+type m3uEntry struct {
+	Title   string // display name after comma
+	TvgId   string
+	TvgName string
+	TvgLogo string
+	Group   string
+	Url     string
+}
+
+func (m *M3UCtx) List(ctx context.Context) ([]Stream, error) {
+	streams := []Stream{}
+	ids := map[string]struct{}{}
+
+	for _, source := range m.config.Sources {
+		entries, err := m3uLoad(ctx, source, m.absPath)
+		if err != nil {
+			return nil, fmt.Errorf("error while loading m3u %s: %w", source, err)
+		}
+
+		for _, entry := range entries {
+			if !m3uGroupAllowed(m.config, entry.Group) {
+				continue
+			}
+
+			name := entry.TvgName
+			if name == "" {
+				name = entry.Title
+			}
+			if name == "" {
+				name = entry.TvgId
+			}
+
+			base := StreamID(name)
+			if base == "" {
+				continue
+			}
+
+			// make duplicate names unique
+			id := base
+			for i := 2; ; i++ {
+				if _, ok := ids[id]; !ok {
+					break
+				}
+				id = base + "_" + strconv.Itoa(i)
+			}
+
+			if id != base {
+				log.Warn().Str("name", name).Str("id", id).Msg("m3u stream ID collision, using unique ID")
+			}
+
+			ids[id] = struct{}{}
+			streams = append(streams, Stream{
+				ID:    id,
+				Url:   entry.Url,
+				Name:  name,
+				TvgId: entry.TvgId,
+				Group: entry.Group,
+				Logo:  entry.TvgLogo,
+			})
+		}
+	}
+
+	return streams, nil
+}
+
+// get playlist from http url or local file, relative to base dir
+func m3uLoad(ctx context.Context, source string, absPath func(elem ...string) string) ([]m3uEntry, error) {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		body, err := httpGet(ctx, source)
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+
+		return parseM3U(body)
+	}
+
+	if !strings.HasPrefix(source, "/") {
+		source = absPath(source)
+	}
+
+	file, err := os.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parseM3U(file)
+}
+
+func parseM3U(reader io.Reader) ([]m3uEntry, error) {
+	entries := []m3uEntry{}
+
+	var current *m3uEntry
+	group := "" // from #EXTGRP, applies to next entry
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "#EXTINF:"):
+			entry := parseM3UInfo(strings.TrimPrefix(line, "#EXTINF:"))
+			current = &entry
+		case strings.HasPrefix(line, "#EXTGRP:"):
+			group = strings.TrimSpace(strings.TrimPrefix(line, "#EXTGRP:"))
+		case strings.HasPrefix(line, "#"):
+			// other tags are not needed
+		default:
+			if current == nil {
+				// url without #EXTINF
+				current = &m3uEntry{}
+			}
+
+			if current.Group == "" {
+				current.Group = group
+			}
+
+			current.Url = line
+			entries = append(entries, *current)
+			current, group = nil, ""
+		}
+	}
+
+	return entries, scanner.Err()
+}
+
+// parse #EXTINF:-1 tvg-id="..." tvg-name="..." tvg-logo="..." group-title="...",Title
+func parseM3UInfo(info string) m3uEntry {
+	// title follows first comma outside of quotes
+	attributes, title := info, ""
+	quoted := false
+	for i, c := range info {
+		if c == '"' {
+			quoted = !quoted
+		} else if c == ',' && !quoted {
+			attributes, title = info[:i], info[i+1:]
+			break
+		}
+	}
+
+	entry := m3uEntry{Title: strings.TrimSpace(title)}
+	for _, match := range m3uAttrRegex.FindAllStringSubmatch(attributes, -1) {
+		value := strings.TrimSpace(match[2])
+		switch strings.ToLower(match[1]) {
+		case "tvg-id":
+			entry.TvgId = value
+		case "tvg-name":
+			entry.TvgName = value
+		case "tvg-logo":
+			entry.TvgLogo = value
+		case "group-title":
+			entry.Group = value
+		}
+	}
+
+	return entry
+}
+
+func m3uGroupAllowed(conf M3UConfig, group string) bool {
+	for _, excluded := range conf.ExcludeGroups {
+		if strings.EqualFold(excluded, group) {
+			return false
+		}
+	}
+
+	if len(conf.IncludeGroups) == 0 {
+		return true
+	}
+
+	for _, included := range conf.IncludeGroups {
+		if strings.EqualFold(included, group) {
+			return true
+		}
+	}
+
+	return false
+}
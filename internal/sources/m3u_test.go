@@ -0,0 +1,48 @@
+package sources
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestM3UList(t *testing.T) {
+	dir := t.TempDir()
+	playlist := `#EXTM3U
+#EXTINF:-1 tvg-id="bbc1.uk" tvg-name="BBC One HD" tvg-logo="http://example.com/bbc1.png" group-title="UK",BBC One
+http://example.com/bbc1.m3u8
+#EXTINF:-1 group-title="UK",BBC One HD
+http://example.com/bbc1-backup.m3u8
+#EXTGRP:Music
+#EXTINF:-1,Radio, "Live"
+http://example.com/radio.mp3
+`
+	if err := os.WriteFile(filepath.Join(dir, "list.m3u"), []byte(playlist), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	absPath := func(elem ...string) string {
+		return filepath.Join(append([]string{dir}, elem...)...)
+	}
+
+	source := NewM3U(M3UConfig{
+		Sources:       []string{"list.m3u"},
+		ExcludeGroups: []string{"music"},
+	}, absPath)
+
+	got, err := source.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Stream{
+		{ID: "bbc_one_hd", Url: "http://example.com/bbc1.m3u8", Name: "BBC One HD", TvgId: "bbc1.uk", Group: "UK", Logo: "http://example.com/bbc1.png"},
+		{ID: "bbc_one_hd_2", Url: "http://example.com/bbc1-backup.m3u8", Name: "BBC One HD", Group: "UK"},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,119 @@
+package sources
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// timeout for source requests
+const requestTimeout = 30 * time.Second
+
+var streamIdRegex = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// Stream is a channel offered by source.
+type Stream struct {
+	ID    string // used in urls, unique within source
+	Url   string
+	Name  string // human readable channel name
+	Radio bool   // audio only channel
+	Group string
+	Logo  string // url
+	TvgId string // EPG channel id
+
+	// Enigma2 service details
+	Reference   string
+	Bouquet     string // bouquet reference
+	BouquetName string
+	Provider    string
+}
+
+// Source imports streams from external channel list.
+type Source interface {
+	// Name identifies streams imported from this source.
+	Name() string
+	// List gets all streams currently offered by source.
+	List(ctx context.Context) ([]Stream, error)
+	// Refresh returns how often should streams be listed again, 0 disables refresh.
+	Refresh() time.Duration
+}
+
+// Config of generic source defined in config file.
+type Config struct {
+	Url     string        // base url, can contain credentials
+	Prefix  string        // prepended to stream IDs
+	Refresh time.Duration // 0 disables refresh
+	Profile string        // stream profile, if supported by source
+}
+
+// New creates source by its type.
+func New(kind string, name string, config Config) (Source, error) {
+	switch kind {
+	case "tvheadend":
+		return NewTvheadend(name, config), nil
+	case "hdhomerun":
+		return NewHDHomeRun(name, config), nil
+	default:
+		return nil, fmt.Errorf("unknown source type %s", kind)
+	}
+}
+
+// StreamID returns ID usable in urls, e.g. "BBC One HD" becomes bbc_one_hd,
+// names without any latin letters or digits get ID derived from their hash.
+func StreamID(name string) string {
+	id := streamIdRegex.ReplaceAllString(strings.ToLower(name), "_")
+	id = strings.Trim(id, "_-")
+
+	if id == "" && strings.TrimSpace(name) != "" {
+		hash := fnv.New32a()
+		_, _ = hash.Write([]byte(name))
+		id = fmt.Sprintf("ch_%08x", hash.Sum32())
+
+		log.Warn().Str("name", name).Str("id", id).Msg("stream name can not be used as ID, using its hash")
+	}
+
+	return id
+}
+
+func httpGet(ctx context.Context, url string) (io.ReadCloser, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("http get error: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("status error: %d", resp.StatusCode)
+	}
+
+	return &cancelBody{resp.Body, cancel}, nil
+}
+
+// releases request context when body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
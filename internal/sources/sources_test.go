@@ -0,0 +1,18 @@
+package sources
+
+import "testing"
+
+func TestStreamID(t *testing.T) {
+	tests := map[string]string{
+		"BBC One HD":   "bbc_one_hd",
+		" -Sat.1- ":    "sat_1",
+		"":             "",
+		"Первый канал": "ch_25cbba8d",
+	}
+
+	for name, want := range tests {
+		if got := StreamID(name); got != want {
+			t.Errorf("StreamID(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package sources
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// more channels than any real setup has, grid api is paginated
+const tvheadendLimit = 100000
+
+type TvheadendCtx struct {
+	name   string
+	config Config
+}
+
+// NewTvheadend creates source importing enabled channels from Tvheadend HTTP API.
+func NewTvheadend(name string, config Config) *TvheadendCtx {
+	return &TvheadendCtx{name, config}
+}
+
+func (t *TvheadendCtx) Name() string {
+	return t.name
+}
+
+func (t *TvheadendCtx) Refresh() time.Duration {
+	return t.config.Refresh
+}
+
+type tvheadendChannel struct {
+	Uuid    string   `json:"uuid"`
+	Enabled bool     `json:"enabled"`
+	Name    string   `json:"name"`
+	Number  float64  `json:"number"` // can be e.g. 1.2 for sub-channels
+	Icon    string   `json:"icon_public_url"`
+	Tags    []string `json:"tags"`
+}
+
+type tvheadendTag struct {
+	Uuid string `json:"uuid"`
+	Name string `json:"name"`
+}
+
+func (t *TvheadendCtx) List(ctx context.Context) ([]Stream, error) {
+	baseUrl, err := url.Parse(t.config.Url)
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing tvheadend url: %w", err)
+	}
+
+	var channels struct {
+		Entries []tvheadendChannel `json:"entries"`
+	}
+	if err := tvheadendGet(ctx, baseUrl, "/api/channel/grid", &channels); err != nil {
+		return nil, fmt.Errorf("error while getting tvheadend channels: %w", err)
+	}
+
+	// tags are used as groups, they are optional
+	var tags struct {
+		Entries []tvheadendTag `json:"entries"`
+	}
+	if err := tvheadendGet(ctx, baseUrl, "/api/channeltag/grid", &tags); err != nil {
+		log.Debug().Err(err).Msg("unable to get tvheadend tags")
+	}
+
+	tagNames := map[string]string{}
+	for _, tag := range tags.Entries {
+		tagNames[tag.Uuid] = tag.Name
+	}
+
+	// same order as in Tvheadend clients
+	sort.SliceStable(channels.Entries, func(i, j int) bool {
+		return channels.Entries[i].Number < channels.Entries[j].Number
+	})
+
+	streams := []Stream{}
+	ids := map[string]struct{}{}
+	for _, channel := range channels.Entries {
+		if !channel.Enabled || channel.Uuid == "" {
+			continue
+		}
+
+		name := StreamID(channel.Name)
+		if name == "" {
+			name = channel.Uuid
+		}
+		name = t.config.Prefix + name
+
+		// make duplicate names unique
+		id := name
+		for i := 2; ; i++ {
+			if _, ok := ids[id]; !ok {
+				break
+			}
+			id = name + "_" + strconv.Itoa(i)
+		}
+		ids[id] = struct{}{}
+
+		chUrl := *baseUrl
+		chUrl.Path = path.Join(chUrl.Path, "/stream/channel", channel.Uuid)
+		chUrl.RawQuery = ""
+		if t.config.Profile != "" {
+			chUrl.RawQuery = url.Values{"profile": {t.config.Profile}}.Encode()
+		}
+
+		stream := Stream{
+			ID:   id,
+			Url:  chUrl.String(),
+			Name: channel.Name,
+		}
+
+		for _, tag := range channel.Tags {
+			if group, ok := tagNames[tag]; ok {
+				stream.Group = group
+				break
+			}
+		}
+
+		// icon is relative to web root, if cached by Tvheadend
+		if channel.Icon != "" {
+			if iconUrl, err := url.Parse(channel.Icon); err == nil {
+				if !iconUrl.IsAbs() {
+					iconUrl = tvheadendPublicUrl(baseUrl).ResolveReference(iconUrl)
+				}
+				stream.Logo = iconUrl.String()
+			}
+		}
+
+		streams = append(streams, stream)
+	}
+
+	return streams, nil
+}
+
+func tvheadendGet(ctx context.Context, baseUrl *url.URL, endpoint string, obj interface{}) error {
+	apiUrl := *baseUrl
+	apiUrl.Path = path.Join(apiUrl.Path, endpoint)
+	apiUrl.RawQuery = url.Values{"all": {"1"}, "limit": {strconv.Itoa(tvheadendLimit)}}.Encode()
+
+	body, err := httpGet(ctx, apiUrl.String())
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	return json.NewDecoder(body).Decode(obj)
+}
+
+// base url without credentials, with trailing slash
+func tvheadendPublicUrl(baseUrl *url.URL) *url.URL {
+	publicUrl := *baseUrl
+	publicUrl.User = nil
+	publicUrl.RawQuery = ""
+	if publicUrl.Path == "" || publicUrl.Path[len(publicUrl.Path)-1] != '/' {
+		publicUrl.Path += "/"
+	}
+	return &publicUrl
+}
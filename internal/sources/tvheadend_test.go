@@ -0,0 +1,59 @@
+package sources
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestTvheadendList(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tvh/api/channel/grid":
+			fmt.Fprint(w, `{"entries": [
+				{"uuid": "b2", "enabled": true, "name": "ZDF HD", "number": 2, "tags": ["t1"]},
+				{"uuid": "a1", "enabled": true, "name": "Das Erste HD", "number": 1, "icon_public_url": "imagecache/1", "tags": ["t0", "t1"]},
+				{"uuid": "c3", "enabled": false, "name": "Disabled", "number": 3}
+			], "total": 3}`)
+		case "/tvh/api/channeltag/grid":
+			fmt.Fprint(w, `{"entries": [{"uuid": "t1", "name": "HD"}], "total": 1}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	source := NewTvheadend("tvh", Config{
+		Url:     server.URL + "/tvh",
+		Prefix:  "tvh_",
+		Profile: "pass",
+	})
+
+	got, err := source.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Stream{
+		{
+			ID:    "tvh_das_erste_hd",
+			Url:   server.URL + "/tvh/stream/channel/a1?profile=pass",
+			Name:  "Das Erste HD",
+			Group: "HD",
+			Logo:  server.URL + "/tvh/imagecache/1",
+		},
+		{
+			ID:    "tvh_zdf_hd",
+			Url:   server.URL + "/tvh/stream/channel/b2?profile=pass",
+			Name:  "ZDF HD",
+			Group: "HD",
+		},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %+v, want %+v", got, want)
+	}
+}
@@ -64,6 +64,21 @@ func (c *Cache) Close() error {
 	return nil
 }
 
+// Closed returns whether writing has finished.
+func (c *Cache) Closed() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.closed
+}
+
+func (c *Cache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.length
+}
+
 func (c *Cache) ServeHTTP(w http.ResponseWriter) {
 	offset, index := 0, 0
 
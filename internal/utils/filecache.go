@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileCache is disk backed alternative to Cache, that can be served while still being written.
+type FileCache struct {
+	mu     sync.RWMutex
+	file   *os.File
+	path   string
+	length int
+	closed bool
+	notify chan struct{}
+
+	Expires time.Time
+}
+
+func NewFileCache(path string, expires time.Time) (*FileCache, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileCache{
+		file:    file,
+		path:    path,
+		notify:  make(chan struct{}),
+		Expires: expires,
+	}, nil
+}
+
+func (c *FileCache) Write(p []byte) (n int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	n, err = c.file.Write(p)
+	c.length += n
+
+	// broadcast
+	close(c.notify)
+	c.notify = make(chan struct{})
+
+	return
+}
+
+func (c *FileCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+
+	c.closed = true
+	close(c.notify)
+
+	return c.file.Close()
+}
+
+// Closed returns whether writing has finished.
+func (c *FileCache) Closed() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.closed
+}
+
+func (c *FileCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.length
+}
+
+// Remove deletes file from disk, readers that already opened it can finish.
+func (c *FileCache) Remove() error {
+	_ = c.Close()
+	return os.Remove(c.path)
+}
+
+func (c *FileCache) ServeHTTP(w http.ResponseWriter) {
+	file, err := os.Open(c.path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	offset := 0
+	buf := make([]byte, 32*1024)
+
+	for {
+		// read current state
+		c.mu.RLock()
+		length, closed, notify := c.length, c.closed, c.notify
+		c.mu.RUnlock()
+
+		// send all available data
+		for offset < length {
+			size := len(buf)
+			if length-offset < size {
+				size = length - offset
+			}
+
+			n, err := file.Read(buf[:size])
+			if n > 0 {
+				if _, err := w.Write(buf[:n]); err != nil {
+					return
+				}
+				offset += n
+			}
+
+			if err != nil && err != io.EOF {
+				return
+			}
+
+			// data not yet readable
+			if n == 0 {
+				break
+			}
+		}
+
+		// if stream is already closed
+		if closed {
+			return
+		}
+
+		// wait for new data
+		<-notify
+	}
+}
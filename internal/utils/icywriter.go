@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"io"
+	"strings"
+)
+
+// IcyWriterCtx inserts ICY metadata blocks into audio stream every metaInt bytes.
+type IcyWriterCtx struct {
+	writer   io.Writer
+	metaInt  int
+	metadata []byte
+	written  int
+}
+
+func IcyWriter(w io.Writer, metaInt int, title string) *IcyWriterCtx {
+	return &IcyWriterCtx{
+		writer:   w,
+		metaInt:  metaInt,
+		metadata: IcyMetadata(title),
+	}
+}
+
+func (i *IcyWriterCtx) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		chunk := i.metaInt - i.written
+		if chunk > len(p) {
+			chunk = len(p)
+		}
+
+		c, err := i.writer.Write(p[:chunk])
+		n += c
+		i.written += c
+		if err != nil {
+			return n, err
+		}
+
+		p = p[chunk:]
+
+		// metadata block after every metaInt bytes of audio
+		if i.written == i.metaInt {
+			if _, err := i.writer.Write(i.metadata); err != nil {
+				return n, err
+			}
+
+			i.written = 0
+		}
+	}
+
+	return n, nil
+}
+
+// IcyMetadata creates metadata block: length byte (in 16 byte units) followed by padded data.
+func IcyMetadata(title string) []byte {
+	title = strings.ReplaceAll(title, "'", "")
+	data := []byte("StreamTitle='" + title + "';")
+
+	// maximum length is 255 * 16 bytes
+	if len(data) > 255*16 {
+		data = data[:255*16]
+	}
+
+	blocks := (len(data) + 15) / 16
+	block := make([]byte, 1+blocks*16)
+	block[0] = byte(blocks)
+	copy(block[1:], data)
+
+	return block
+}
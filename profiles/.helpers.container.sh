@@ -0,0 +1,18 @@
+
+#
+# CONTAINER: ts (default), mp4 (fragmented, for browsers), mkv, webm
+#
+case "$CONTAINER" in
+  mp4)
+    export FORMATPARAMS="-f mp4 -movflags frag_keyframe+empty_moov+default_base_moof"
+    ;;
+  mkv)
+    export FORMATPARAMS="-f matroska -live 1"
+    ;;
+  webm)
+    export FORMATPARAMS="-f webm -live 1"
+    ;;
+  *)
+    export FORMATPARAMS="-f mpegts"
+    ;;
+esac
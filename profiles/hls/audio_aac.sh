@@ -0,0 +1,7 @@
+#!/bin/sh
+
+export ACODEC="aac"
+export ASAMPLERATE="48000"
+export ABANDWIDTH="128k"
+
+exec "$(dirname "$0")"/../hls_audio.sh "$1"
@@ -0,0 +1,7 @@
+#!/bin/sh
+
+export ACODEC="libmp3lame"
+export ASAMPLERATE="44100"
+export ABANDWIDTH="128k"
+
+exec "$(dirname "$0")"/../hls_audio.sh "$1"
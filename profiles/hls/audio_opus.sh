@@ -0,0 +1,7 @@
+#!/bin/sh
+
+export ACODEC="libopus"
+export ASAMPLERATE="48000"
+export ABANDWIDTH="96k"
+
+exec "$(dirname "$0")"/../hls_audio.sh "$1"
@@ -0,0 +1,12 @@
+#!/bin/sh
+
+export VW="1280"
+export VH="720"
+export ABANDWIDTH="128k"
+export VBANDWIDTH="2800k"
+export VMAXRATE="2996k"
+export VBUFSIZE="4200k"
+
+export SUBTITLES="burn"
+
+exec "$(dirname "$0")"/../hls_h264.sh "$1"
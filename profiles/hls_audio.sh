@@ -0,0 +1,32 @@
+#!/usr/bin/env bash
+
+export INPUT="$1"
+
+if [[ "$ACODEC" = "" ]]; then echo "Missing \$ACODEC"; exit 1; fi
+if [[ "$ABANDWIDTH" = "" ]]; then echo "Missing \$ABANDWIDTH"; exit 1; fi
+if [[ "$ASAMPLERATE" = "" ]]; then echo "Missing \$ASAMPLERATE"; exit 1; fi
+
+# opus is only supported in fMP4 segments
+if [[ "$ACODEC" = "libopus" ]]; then
+  SEGMENT_PARAMS="-hls_segment_type fmp4 -hls_fmp4_init_filename init.mp4"
+  SEGMENT_EXT="m4s"
+else
+  SEGMENT_PARAMS="-hls_segment_type mpegts"
+  SEGMENT_EXT="ts"
+fi
+
+exec ffmpeg -hide_banner -loglevel warning \
+  -i "$INPUT" \
+  -map 0:a:0 -vn \
+    -c:a $ACODEC \
+      -ar $ASAMPLERATE \
+      -ac 2 \
+      -b:a $ABANDWIDTH \
+  -f hls \
+    -hls_time 2 \
+    -hls_list_size 5 \
+    -hls_delete_threshold 1 \
+    -hls_flags delete_segments+independent_segments \
+    -hls_start_number_source datetime \
+    $SEGMENT_PARAMS \
+    -hls_segment_filename "audio_%03d.$SEGMENT_EXT" -
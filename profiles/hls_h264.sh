@@ -9,7 +9,23 @@ if [[ "$VBANDWIDTH" = "" ]]; then echo "Missing \$VBANDWIDTH"; exit 1; fi
 if [[ "$VMAXRATE" = "" ]]; then echo "Missing \$VMAXRATE"; exit 1; fi
 if [[ "$VBUFSIZE" = "" ]]; then echo "Missing \$VBUFSIZE"; exit 1; fi
 
-source "$(dirname "$0")/.helpers.hwaccel_h264.sh"
+#
+# SUBTITLES: "webvtt" extracts text subtitles and teletext, "burn" overlays (bitmap) subtitles
+#
+if [ -n "$SUBTITLES" ]; then
+  # single probe, bounded so that unresponsive input does not block start
+  SUBTITLE_PROBE="$(ffprobe -hide_banner -loglevel panic \
+    -rw_timeout 5000000 -analyzeduration 5000000 \
+    -select_streams s:0 -show_entries stream=codec_name:stream_tags=language \
+    -of default=noprint_wrappers=1 "$INPUT")"
+  SUBTITLE_CODEC="$(echo "$SUBTITLE_PROBE" | sed -n 's/^codec_name=//p' | head -1)"
+  SUBTITLE_LANG="$(echo "$SUBTITLE_PROBE" | sed -n 's/^TAG:language=//p' | head -1)"
+fi
+
+# hardware filters cannot overlay subtitles
+if [ "$SUBTITLES" != "burn" ] || [ -z "$SUBTITLE_CODEC" ]; then
+  source "$(dirname "$0")/.helpers.hwaccel_h264.sh"
+fi
 
 if [ -z "$CV" ] || [ -z "$VF" ]; then
   echo "Using CPU encoding."
@@ -18,11 +34,38 @@ if [ -z "$CV" ] || [ -z "$VF" ]; then
   CV="h264"
 fi
 
+VIDEOPARAMS="-map 0:v:0 -map 0:a:0 -vf $VF"
+SUBTITLEOUTPUT=""
+
+if [ "$SUBTITLES" = "burn" ] && [ -n "$SUBTITLE_CODEC" ]; then
+  echo "Burning in $SUBTITLE_CODEC subtitles." >&2
+  VIDEOPARAMS="-filter_complex [0:v:0][0:s:0]overlay,$VF[v] -map [v] -map 0:a:0"
+elif [ "$SUBTITLES" = "webvtt" ] && [[ " dvb_teletext subrip ass ssa mov_text webvtt text " =~ " ${SUBTITLE_CODEC} " ]]; then
+  echo "Extracting $SUBTITLE_CODEC subtitles to WebVTT." >&2
+
+  # teletext needs to be decoded as text, only subtitle pages
+  if [ "$SUBTITLE_CODEC" = "dvb_teletext" ]; then
+    EXTRAPARAMS="$EXTRAPARAMS -txt_format text -txt_page subtitle"
+  fi
+
+  # language advertised in master playlist
+  echo "$SUBTITLE_LANG" > subtitles.lang
+
+  # hls muxer writes X-TIMESTAMP-MAP to WebVTT segments, so that they are in sync with video,
+  # subtitles go to their own playlist, the other one of this output stays empty
+  SUBTITLEOUTPUT="-map 0:s:0 -c:s webvtt \
+    -f hls \
+      -hls_time 2 \
+      -hls_list_size 5 \
+      -hls_flags delete_segments \
+      -hls_subtitle_path subtitles.m3u8 \
+      subtitles_ts.m3u8"
+fi
+
 exec ffmpeg -hide_banner -loglevel warning \
   $EXTRAPARAMS \
   -i "$INPUT" \
-  -map 0:v:0 -map 0:a:0 \
-  -vf $VF \
+  $VIDEOPARAMS \
     -c:a aac \
       -ar 48000 \
       -ac 2 \
@@ -45,4 +88,5 @@ exec ffmpeg -hide_banner -loglevel warning \
     -hls_flags delete_segments+second_level_segment_index \
     -hls_start_number_source datetime \
     -strftime 1 \
-    -hls_segment_filename "live_%Y%m%d%H%M%S_%%03d.ts" -
+    -hls_segment_filename "live_%Y%m%d%H%M%S_%%03d.ts" - \
+  $SUBTITLEOUTPUT
@@ -0,0 +1,8 @@
+#!/bin/sh
+
+export ACODEC="aac"
+export AFORMAT="adts"
+export ASAMPLERATE="48000"
+export ABANDWIDTH="128k"
+
+exec "$(dirname "$0")"/../http_audio.sh "$1"
@@ -0,0 +1,8 @@
+#!/bin/sh
+
+export ACODEC="libmp3lame"
+export AFORMAT="mp3"
+export ASAMPLERATE="44100"
+export ABANDWIDTH="128k"
+
+exec "$(dirname "$0")"/../http_audio.sh "$1"
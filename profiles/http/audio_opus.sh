@@ -0,0 +1,8 @@
+#!/bin/sh
+
+export ACODEC="libopus"
+export AFORMAT="ogg"
+export ASAMPLERATE="48000"
+export ABANDWIDTH="96k"
+
+exec "$(dirname "$0")"/../http_audio.sh "$1"
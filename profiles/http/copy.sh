@@ -1,7 +1,14 @@
-#!/bin/sh
+#!/usr/bin/env bash
+
+# copied codecs are usually not allowed in webm, use matroska which it is subset of
+if [ "$CONTAINER" = "webm" ]; then
+  export CONTAINER="mkv"
+fi
+
+source "$(dirname "$0")/../.helpers.container.sh"
 
 exec ffmpeg -hide_banner -loglevel warning \
   -i "${1}" \
   -c:a copy \
   -c:v copy \
-  -f mpegts -
+  $FORMATPARAMS -
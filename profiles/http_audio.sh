@@ -0,0 +1,17 @@
+#!/usr/bin/env bash
+
+export INPUT="$1"
+
+if [[ "$ACODEC" = "" ]]; then echo "Missing \$ACODEC"; exit 1; fi
+if [[ "$AFORMAT" = "" ]]; then echo "Missing \$AFORMAT"; exit 1; fi
+if [[ "$ABANDWIDTH" = "" ]]; then echo "Missing \$ABANDWIDTH"; exit 1; fi
+if [[ "$ASAMPLERATE" = "" ]]; then echo "Missing \$ASAMPLERATE"; exit 1; fi
+
+exec ffmpeg -hide_banner -loglevel warning \
+  -i "$INPUT" \
+  -map 0:a:0 -vn \
+    -c:a $ACODEC \
+      -ar $ASAMPLERATE \
+      -ac 2 \
+      -b:a $ABANDWIDTH \
+  -f $AFORMAT -
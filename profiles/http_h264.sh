@@ -9,10 +9,25 @@ if [[ "$VBANDWIDTH" = "" ]]; then echo "Missing \$VBANDWIDTH"; exit 1; fi
 if [[ "$VMAXRATE" = "" ]]; then echo "Missing \$VMAXRATE"; exit 1; fi
 if [[ "$VBUFSIZE" = "" ]]; then echo "Missing \$VBUFSIZE"; exit 1; fi
 
-source "$(dirname "$0")/.helpers.hwaccel_h264.sh"
+source "$(dirname "$0")/.helpers.container.sh"
+
+# webm only supports VP9 and Opus, encoded by CPU
+if [ "$CONTAINER" = "webm" ]; then
+  echo "Using CPU encoding for WebM." >&2
+
+  VF="scale=w=$VW:h=$VH:force_original_aspect_ratio=decrease"
+  CV="libvpx-vp9"
+  CA="libopus"
+  VPARAMS="-deadline realtime -cpu-used 8 -row-mt 1"
+else
+  source "$(dirname "$0")/.helpers.hwaccel_h264.sh"
+
+  CA="aac"
+  VPARAMS="-profile:v main"
+fi
 
 if [ -z "$CV" ] || [ -z "$VF" ]; then
-  echo "Using CPU encoding."
+  echo "Using CPU encoding." >&2
 
   VF="scale=w=$VW:h=$VH:force_original_aspect_ratio=decrease"
   CV="h264"
@@ -22,12 +37,12 @@ exec ffmpeg -hide_banner -loglevel warning \
   $EXTRAPARAMS \
   -i "$INPUT" \
   -vf $VF \
-    -c:a aac \
+    -c:a $CA \
       -ar 48000 \
       -ac 2 \
       -b:a $ABANDWIDTH \
     -c:v $CV \
-      -profile:v main \
+      $VPARAMS \
       -b:v $VBANDWIDTH \
       -maxrate $VMAXRATE \
       -bufsize $VBUFSIZE \
@@ -36,4 +51,4 @@ exec ffmpeg -hide_banner -loglevel warning \
       -g 48 \
       -keyint_min 48 \
     $EXTRAOUTPUTPARAMS \
-  -f mpegts -
+  $FORMATPARAMS -
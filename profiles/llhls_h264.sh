@@ -0,0 +1,50 @@
+#!/usr/bin/env bash
+
+export INPUT="$1"
+
+if [[ "$VW" = "" ]]; then echo "Missing \$VW"; exit 1; fi
+if [[ "$VH" = "" ]]; then echo "Missing \$VH"; exit 1; fi
+if [[ "$ABANDWIDTH" = "" ]]; then echo "Missing \$ABANDWIDTH"; exit 1; fi
+if [[ "$VBANDWIDTH" = "" ]]; then echo "Missing \$VBANDWIDTH"; exit 1; fi
+if [[ "$VMAXRATE" = "" ]]; then echo "Missing \$VMAXRATE"; exit 1; fi
+if [[ "$VBUFSIZE" = "" ]]; then echo "Missing \$VBUFSIZE"; exit 1; fi
+
+# provided by go-transcode, parts are grouped to segments by the server
+if [[ "$HLS_PART_DURATION" = "" ]]; then echo "Missing \$HLS_PART_DURATION"; exit 1; fi
+if [[ "$HLS_SEGMENT_DURATION" = "" ]]; then echo "Missing \$HLS_SEGMENT_DURATION"; exit 1; fi
+
+source "$(dirname "$0")/.helpers.hwaccel_h264.sh"
+
+if [ -z "$CV" ] || [ -z "$VF" ]; then
+  echo "Using CPU encoding."
+
+  VF="scale=w=$VW:h=$VH:force_original_aspect_ratio=decrease"
+  CV="h264"
+fi
+
+exec ffmpeg -hide_banner -loglevel warning \
+  $EXTRAPARAMS \
+  -i "$INPUT" \
+  -map 0:v:0 -map 0:a:0 \
+  -vf $VF \
+    -c:a aac \
+      -ar 48000 \
+      -ac 2 \
+      -b:a $ABANDWIDTH \
+    -c:v $CV \
+      -profile:v main \
+      -force_key_frames "expr:gte(t,n_forced*$HLS_SEGMENT_DURATION)" \
+      -b:v $VBANDWIDTH \
+      -maxrate $VMAXRATE \
+      -bufsize $VBUFSIZE \
+      -crf 20 \
+      -sc_threshold 0 \
+    $EXTRAOUTPUTPARAMS \
+  -f hls \
+    -hls_time $HLS_PART_DURATION \
+    -hls_list_size 32 \
+    -hls_delete_threshold 4 \
+    -hls_segment_type fmp4 \
+    -hls_fmp4_init_filename "init.mp4" \
+    -hls_flags delete_segments+split_by_time+temp_file \
+    -hls_segment_filename "part_%d.m4s" -